@@ -0,0 +1,194 @@
+package grada
+
+// Alert rules that watch metrics and report when a condition starts or stops
+// to hold.
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+// AlertState is the state of an alert rule.
+type AlertState int
+
+const (
+	// AlertResolved means that the condition of the rule does not hold.
+	// This is also the initial state of a rule.
+	AlertResolved AlertState = iota
+	// AlertFiring means that the condition of the rule holds.
+	AlertFiring
+)
+
+func (s AlertState) String() string {
+	if s == AlertFiring {
+		return "firing"
+	}
+	return "resolved"
+}
+
+// AlertCondition checks a metric at time now. It returns the value that
+// the condition is based on, and whether the condition holds. m is nil
+// if the metric does not exist.
+type AlertCondition func(m *Metric, now time.Time) (value float64, holds bool)
+
+// Above returns a condition that holds if the newest value of the metric
+// is above threshold.
+func Above(threshold float64) AlertCondition {
+	return func(m *Metric, now time.Time) (float64, bool) {
+		c, ok := last(m)
+		return c.N, ok && c.N > threshold
+	}
+}
+
+// Below returns a condition that holds if the newest value of the metric
+// is below threshold.
+func Below(threshold float64) AlertCondition {
+	return func(m *Metric, now time.Time) (float64, bool) {
+		c, ok := last(m)
+		return c.N, ok && c.N < threshold
+	}
+}
+
+// Absent returns a condition that holds if the metric has not received
+// a data point for the duration age, or if the metric does not exist.
+func Absent(age time.Duration) AlertCondition {
+	return func(m *Metric, now time.Time) (float64, bool) {
+		c, ok := last(m)
+		return c.N, !ok || now.Sub(c.T) > age
+	}
+}
+
+// RateOfChange returns a condition that holds if the value of the metric
+// changes faster than maxPerSecond, up or down, within the window before
+// the evaluation. The rate is the difference between the oldest and the newest
+// data point in the window, divided by the time between them.
+func RateOfChange(window time.Duration, maxPerSecond float64) AlertCondition {
+	return func(m *Metric, now time.Time) (float64, bool) {
+		if m == nil {
+			return math.NaN(), false
+		}
+		counts := m.Range(now.Add(-window), now)
+		if len(counts) < 2 {
+			return math.NaN(), false
+		}
+		first, last := counts[0], counts[len(counts)-1]
+		secs := last.T.Sub(first.T).Seconds()
+		if secs <= 0 {
+			return math.NaN(), false
+		}
+		rate := (last.N - first.N) / secs
+		return rate, math.Abs(rate) > maxPerSecond
+	}
+}
+
+// last returns the newest data point of m, or NaN and false if m is nil or empty.
+func last(m *Metric) (Count, bool) {
+	if m == nil {
+		return Count{N: math.NaN()}, false
+	}
+	c, ok := m.Last()
+	if !ok {
+		c.N = math.NaN()
+	}
+	return c, ok
+}
+
+// AlertRule describes a condition to watch for a metric.
+type AlertRule struct {
+	// Name identifies the rule in alerts and annotations.
+	Name string
+
+	// Target is the target name of the metric to watch.
+	// The metric does not need to exist when the rule gets added.
+	Target string
+
+	// Condition is the condition that makes the rule fire,
+	// for example Above(90).
+	Condition AlertCondition
+
+	// For is how long the condition must hold before the rule fires.
+	// If For is zero, the rule fires at the first evaluation that
+	// finds the condition to hold.
+	For time.Duration
+
+	// OnChange, if not nil, gets called when the rule fires or resolves.
+	// It runs in the goroutine that evaluates the rule, and should
+	// therefore return quickly.
+	OnChange func(Alert)
+}
+
+// Alert reports that an alert rule has fired or resolved.
+type Alert struct {
+	Rule   string
+	Target string
+	State  AlertState
+	Value  float64 // the value that the condition was based on
+	Time   time.Time
+}
+
+// alertRule is an AlertRule with its state.
+type alertRule struct {
+	AlertRule
+	state AlertState
+	since time.Time // when the condition started to hold, or zero
+}
+
+// AddAlert starts evaluating rule every interval. When the rule fires or
+// resolves, AddAlert calls rule.OnChange and adds an annotation
+// (see Annotate()) with the title "<name> firing" or "<name> resolved",
+// the target and the value as text, and the tags "alert", the name of the rule,
+// and the new state.
+//
+// Evaluation stops when the returned function is called,
+// or when the dashboard shuts down.
+//
+// AddAlert returns an error if the rule has no name, target, or condition.
+func (d *Dashboard) AddAlert(rule AlertRule, interval time.Duration) (stop func(), err error) {
+	if rule.Name == "" || rule.Target == "" || rule.Condition == nil {
+		return nil, errors.New("alert rule needs a name, a target, and a condition")
+	}
+	r := &alertRule{AlertRule: rule}
+	return d.every(interval, func(t time.Time) bool {
+		r.evaluate(d, t)
+		return true
+	}), nil
+}
+
+// evaluate checks the condition at time now and reports state changes.
+func (r *alertRule) evaluate(d *Dashboard, now time.Time) {
+	m, err := d.srv.metrics.Get(r.Target)
+	if err != nil {
+		m = nil
+	}
+	value, holds := r.Condition(m, now)
+
+	state := r.state
+	if !holds {
+		r.since = time.Time{}
+		state = AlertResolved
+	} else {
+		if r.since.IsZero() {
+			r.since = now
+		}
+		if now.Sub(r.since) >= r.For {
+			state = AlertFiring
+		}
+	}
+	if state == r.state {
+		return
+	}
+	r.state = state
+
+	a := Alert{Rule: r.Name, Target: r.Target, State: state, Value: value, Time: now}
+	d.Annotate(Annotation{
+		Time:  now,
+		Title: r.Name + " " + state.String(),
+		Text:  r.Target + " = " + strconv.FormatFloat(value, 'g', -1, 64),
+		Tags:  []string{"alert", r.Name, state.String()},
+	})
+	if r.OnChange != nil {
+		r.OnChange(a)
+	}
+}
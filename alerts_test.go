@@ -0,0 +1,112 @@
+package grada
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAlertConditions(t *testing.T) {
+	start := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+	d := newTestDashboard()
+	m, _ := d.CreateMetricWithBufSize("cpu", 10)
+	empty, _ := d.CreateMetricWithBufSize("empty", 10)
+	for i, v := range []float64{10, 20, 40, 95} {
+		m.AddWithTime(v, start.Add(time.Duration(i)*time.Second))
+	}
+	now := start.Add(3 * time.Second)
+
+	tests := []struct {
+		name      string
+		cond      AlertCondition
+		m         *Metric
+		now       time.Time
+		wantValue float64
+		wantHolds bool
+	}{
+		{"above", Above(90), m, now, 95, true},
+		{"notAbove", Above(95), m, now, 95, false},
+		{"below", Below(100), m, now, 95, true},
+		{"belowNoData", Below(100), empty, now, math.NaN(), false},
+		{"belowNoMetric", Below(100), nil, now, math.NaN(), false},
+		{"present", Absent(time.Second), m, now.Add(time.Second), 95, false},
+		{"absent", Absent(time.Second), m, now.Add(2 * time.Second), 95, true},
+		{"absentNoData", Absent(time.Second), empty, now, math.NaN(), true},
+		{"absentNoMetric", Absent(time.Second), nil, now, math.NaN(), true},
+		{"rate", RateOfChange(2*time.Second, 30), m, now, 37.5, true}, // (95-20)/2s
+		{"slowRate", RateOfChange(2*time.Second, 40), m, now, 37.5, false},
+		{"falling", RateOfChange(10*time.Second, 1), m, now.Add(10 * time.Second), math.NaN(), false},
+		{"rateNoMetric", RateOfChange(time.Second, 1), nil, now, math.NaN(), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, holds := tt.cond(tt.m, tt.now)
+			if holds != tt.wantHolds || (v != tt.wantValue && !(math.IsNaN(v) && math.IsNaN(tt.wantValue))) {
+				t.Errorf("got %f, %t, want %f, %t", v, holds, tt.wantValue, tt.wantHolds)
+			}
+		})
+	}
+}
+
+func TestAlertRule_evaluate(t *testing.T) {
+	start := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+	d := newTestDashboard()
+	m, _ := d.CreateMetricWithBufSize("queue", 10)
+
+	var alerts []Alert
+	r := &alertRule{AlertRule: AlertRule{
+		Name:      "queue full",
+		Target:    "queue",
+		Condition: Above(100),
+		For:       2 * time.Second,
+		OnChange:  func(a Alert) { alerts = append(alerts, a) },
+	}}
+
+	values := []float64{50, 150, 150, 50, 150, 150, 150, 150, 20}
+	want := []AlertState{AlertResolved, AlertResolved, AlertResolved, AlertResolved, AlertResolved, AlertResolved, AlertFiring, AlertFiring, AlertResolved}
+	for i, v := range values {
+		now := start.Add(time.Duration(i) * time.Second)
+		m.AddWithTime(v, now)
+		r.evaluate(d, now)
+		if r.state != want[i] {
+			t.Errorf("step %d: got state %s, want %s", i, r.state, want[i])
+		}
+	}
+
+	if len(alerts) != 2 ||
+		alerts[0] != (Alert{"queue full", "queue", AlertFiring, 150, start.Add(6 * time.Second)}) ||
+		alerts[1] != (Alert{"queue full", "queue", AlertResolved, 20, start.Add(8 * time.Second)}) {
+		t.Errorf("OnChange(): got %v", alerts)
+	}
+	as := d.Annotations(start, start.Add(time.Minute), "alert", "queue full")
+	if len(as) != 2 || as[0].Title != "queue full firing" || as[0].Text != "queue = 150" || as[1].Title != "queue full resolved" {
+		t.Errorf("annotations: got %+v", as)
+	}
+}
+
+func TestDashboard_AddAlert(t *testing.T) {
+	d := newTestDashboard()
+	if _, err := d.AddAlert(AlertRule{Name: "x", Target: "x"}, time.Second); err == nil {
+		t.Error("AddAlert() without condition: want error")
+	}
+
+	fired := make(chan Alert, 1)
+	stop, err := d.AddAlert(AlertRule{
+		Name:      "missing",
+		Target:    "heartbeat",
+		Condition: Absent(time.Minute),
+		OnChange:  func(a Alert) { fired <- a },
+	}, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	select {
+	case a := <-fired:
+		if a.State != AlertFiring {
+			t.Errorf("AddAlert(): got state %s, want firing", a.State)
+		}
+	case <-time.After(time.Second):
+		t.Error("AddAlert(): rule did not fire")
+	}
+}
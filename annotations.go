@@ -0,0 +1,152 @@
+package grada
+
+// Annotations mark events on the time axis of Grafana panels.
+// Grafana requests them through `/annotations`.
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxAnnotations is the number of annotations that the dashboard keeps.
+// When a new annotation exceeds this number, the oldest one gets dropped.
+const maxAnnotations = 1000
+
+// Annotation is an event that Grafana shows on the time axis of a panel,
+// for example a deployment, an error, or an alert.
+type Annotation struct {
+	Time  time.Time
+	Title string
+	Text  string
+	Tags  []string
+}
+
+// annotations holds the newest annotations in time order.
+// The zero value is an empty list.
+type annotations struct {
+	mu   sync.Mutex
+	list []Annotation
+}
+
+// add inserts a at its position in time. If the list gets too long,
+// add drops the oldest annotation.
+func (as *annotations) add(a Annotation) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	i, _ := slices.BinarySearchFunc(as.list, a.Time, func(e Annotation, t time.Time) int {
+		if e.Time.After(t) {
+			return 1
+		}
+		return -1 // insert after annotations with the same time
+	})
+	as.list = slices.Insert(as.list, i, a)
+	if len(as.list) > maxAnnotations {
+		as.list = slices.Delete(as.list, 0, len(as.list)-maxAnnotations)
+	}
+}
+
+// find returns the annotations between from and to (inclusive) that carry
+// all of the tags.
+func (as *annotations) find(from, to time.Time, tags []string) []Annotation {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	var found []Annotation
+	for _, a := range as.list {
+		if a.Time.Before(from) || a.Time.After(to) {
+			continue
+		}
+		if !hasTags(a, tags) {
+			continue
+		}
+		found = append(found, a)
+	}
+	return found
+}
+
+// hasTags reports whether a carries all of the tags.
+func hasTags(a Annotation, tags []string) bool {
+	for _, tag := range tags {
+		if !slices.Contains(a.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// Annotate adds an annotation to the dashboard. If a.Time is zero,
+// Annotate sets it to the current time.
+//
+// To show annotations in Grafana, add an annotation query to the dashboard
+// settings and select the grada data source. The query text is a list of tags,
+// separated by spaces or commas; Grafana then shows all annotations that carry
+// all of these tags. An empty query shows all annotations.
+//
+// The dashboard keeps the newest 1000 annotations.
+func (d *Dashboard) Annotate(a Annotation) {
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	a.Tags = slices.Clone(a.Tags)
+	d.srv.annotations.add(a)
+}
+
+// Annotations returns the annotations between from and to (inclusive)
+// that carry all of the given tags, in time order.
+func (d *Dashboard) Annotations(from, to time.Time, tags ...string) []Annotation {
+	return d.srv.annotations.find(from, to, tags)
+}
+
+// annotationQuery is an `/annotations` request from Grafana.
+type annotationQuery struct {
+	Range struct {
+		From time.Time `json:"from"`
+		To   time.Time `json:"to"`
+	} `json:"range"`
+	Annotation json.RawMessage `json:"annotation"`
+}
+
+// annotationResponse is an annotation sent back to Grafana.
+type annotationResponse struct {
+	Annotation json.RawMessage `json:"annotation"` // the annotation from the query
+	Time       int64           `json:"time"`       // milliseconds since the Unix epoch
+	Title      string          `json:"title"`
+	Text       string          `json:"text"`
+	Tags       []string        `json:"tags"`
+}
+
+// annotationsHandler responds to annotation queries from Grafana.
+func (srv *server) annotationsHandler(w http.ResponseWriter, r *http.Request) {
+	q := &annotationQuery{}
+	if err := json.NewDecoder(r.Body).Decode(q); err != nil {
+		writeError(w, err, "cannot unmarshal request body")
+		return
+	}
+	var a struct {
+		Query string `json:"query"`
+	}
+	json.Unmarshal(q.Annotation, &a)
+	tags := strings.FieldsFunc(a.Query, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+
+	response := []annotationResponse{}
+	for _, an := range srv.annotations.find(q.Range.From, q.Range.To, tags) {
+		response = append(response, annotationResponse{
+			Annotation: q.Annotation,
+			Time:       an.Time.UnixNano() / 1000000,
+			Title:      an.Title,
+			Text:       an.Text,
+			Tags:       an.Tags,
+		})
+	}
+	jsonResp, err := json.Marshal(response)
+	if err != nil {
+		writeError(w, err, "cannot marshal annotations response")
+		return
+	}
+	w.Write(jsonResp)
+}
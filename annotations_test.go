@@ -0,0 +1,93 @@
+package grada
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDashboard_Annotate(t *testing.T) {
+	d := newTestDashboard()
+	start := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+	d.Annotate(Annotation{Time: start.Add(2 * time.Second), Title: "c", Tags: []string{"deploy", "v2"}})
+	d.Annotate(Annotation{Time: start, Title: "a", Tags: []string{"deploy"}})
+	d.Annotate(Annotation{Time: start.Add(time.Second), Title: "b", Tags: []string{"error"}})
+	d.Annotate(Annotation{Time: start, Title: "a2"})
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		tags     []string
+		want     []string
+	}{
+		{"all", start, start.Add(time.Hour), nil, []string{"a", "a2", "b", "c"}},
+		{"range", start.Add(time.Second), start.Add(2 * time.Second), nil, []string{"b", "c"}},
+		{"tag", start, start.Add(time.Hour), []string{"deploy"}, []string{"a", "c"}},
+		{"tags", start, start.Add(time.Hour), []string{"deploy", "v2"}, []string{"c"}},
+		{"none", start.Add(time.Minute), start.Add(time.Hour), nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, a := range d.Annotations(tt.from, tt.to, tt.tags...) {
+				got = append(got, a.Title)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("Annotations(): got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for i := 0; i < maxAnnotations; i++ {
+		d.Annotate(Annotation{Time: start.Add(time.Duration(i) * time.Millisecond)})
+	}
+	all := d.Annotations(minTime, maxTime)
+	if len(all) != maxAnnotations || all[len(all)-1].Title != "c" {
+		t.Errorf("Annotate(): got %d annotations, want the newest %d", len(all), maxAnnotations)
+	}
+
+	before := time.Now()
+	d.Annotate(Annotation{Title: "now"})
+	if got := d.Annotations(before, time.Now()); len(got) != 1 || got[0].Title != "now" {
+		t.Errorf("Annotate() with zero time: got %v", got)
+	}
+}
+
+func TestServer_annotationsHandler(t *testing.T) {
+	d := newTestDashboard()
+	ts := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+	d.Annotate(Annotation{Time: ts, Title: "deployed", Text: "v2", Tags: []string{"deploy", "v2"}})
+	d.Annotate(Annotation{Time: ts, Title: "failed", Tags: []string{"error"}})
+
+	body := `{
+		"range": {"from": "2017-10-25T11:00:00.000Z", "to": "2017-10-25T12:00:00.000Z"},
+		"annotation": {"name": "deploys", "enable": true, "query": "deploy, v2"}
+	}`
+	w := httptest.NewRecorder()
+	d.srv.annotationsHandler(w, httptest.NewRequest("POST", "/annotations", strings.NewReader(body)))
+
+	var got []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("cannot unmarshal %s: %v", w.Body, err)
+	}
+	want := []map[string]interface{}{{
+		"annotation": map[string]interface{}{"name": "deploys", "enable": true, "query": "deploy, v2"},
+		"time":       float64(1508930214000),
+		"title":      "deployed",
+		"text":       "v2",
+		"tags":       []interface{}{"deploy", "v2"},
+	}}
+	if !cmp.Equal(got, want) {
+		t.Errorf("annotationsHandler(): diff:\n%s", cmp.Diff(got, want))
+	}
+
+	w = httptest.NewRecorder()
+	d.srv.annotationsHandler(w, httptest.NewRequest("POST", "/annotations", strings.NewReader("{")))
+	if w.Code != 400 {
+		t.Errorf("annotationsHandler(): got status %d for an invalid body, want 400", w.Code)
+	}
+}
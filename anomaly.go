@@ -0,0 +1,201 @@
+package grada
+
+// Anomaly detection that derives bands and outlier markers from a metric.
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// AnomalyMethod selects how DetectAnomalies() calculates the expected range
+// of a metric.
+type AnomalyMethod int
+
+const (
+	// ZScore uses the mean and the standard deviation of the
+	// previous Window data points.
+	ZScore AnomalyMethod = iota
+	// EWMA uses an exponentially weighted moving average and variance
+	// with a smoothing factor of 2/(Window+1).
+	EWMA
+)
+
+// AnomalyOptions configure DetectAnomalies(). A nil *AnomalyOptions
+// selects the defaults.
+type AnomalyOptions struct {
+	// Method is the method of calculating the bands. The default is ZScore.
+	Method AnomalyMethod
+
+	// Window is the number of data points that the bands are based on.
+	// The default is 30.
+	Window int
+
+	// Threshold is the distance of the bands from the mean,
+	// in standard deviations. The default is 3.
+	Threshold float64
+}
+
+// anomalyDetector derives the bands and anomalies of a metric.
+type anomalyDetector struct {
+	d      *Dashboard
+	target string
+	size   int
+	opts   AnomalyOptions
+
+	lastT    time.Time // the time of the newest data point processed
+	values   []float64 // ZScore: the previous data points, oldest first
+	n        int       // EWMA: the number of data points processed
+	mean     float64   // EWMA
+	variance float64   // EWMA
+}
+
+// DetectAnomalies watches the metric of target for values outside the
+// expected range, and creates three derived metrics:
+//
+//	target.upper      the upper band, mean + Threshold standard deviations
+//	target.lower      the lower band, mean - Threshold standard deviations
+//	target.anomaly    1 if the value is outside the bands, 0 otherwise
+//
+// The derived metrics appear in /search and /query like any other metric,
+// so Grafana can draw the bands around the metric and highlight the outliers.
+// If target has labels, the suffixes go before the labels, as in
+// `requests.upper{route="users"}`.
+//
+// The bands at each data point are based on the data points before it.
+// Derived data points have the same timestamps as the data points of the
+// metric, and start once the metric has two data points. NaN and infinite
+// values do not count towards the bands.
+//
+// DetectAnomalies processes new data points every interval.
+// It stops when the returned function is called, or when the dashboard
+// shuts down. The derived metrics have the buffer size of the metric.
+//
+// DetectAnomalies returns an error if the metric does not exist.
+func (d *Dashboard) DetectAnomalies(target string, interval time.Duration, opts *AnomalyOptions) (stop func(), err error) {
+	m, err := d.srv.metrics.Get(target)
+	if err != nil {
+		return nil, errors.New("cannot detect anomalies: " + err.Error())
+	}
+	ad := newAnomalyDetector(d, target, m.info(target).Size, opts)
+	return d.every(interval, func(time.Time) bool {
+		ad.update()
+		return true
+	}), nil
+}
+
+// newAnomalyDetector creates an anomalyDetector, replacing zero options
+// by their defaults.
+func newAnomalyDetector(d *Dashboard, target string, size int, opts *AnomalyOptions) *anomalyDetector {
+	ad := &anomalyDetector{d: d, target: target, size: size}
+	if opts != nil {
+		ad.opts = *opts
+	}
+	if ad.opts.Window <= 0 {
+		ad.opts.Window = 30
+	}
+	if ad.opts.Threshold <= 0 {
+		ad.opts.Threshold = 3
+	}
+	return ad
+}
+
+// update processes the data points that were added since the previous update.
+func (ad *anomalyDetector) update() {
+	m, err := ad.d.srv.metrics.Get(ad.target)
+	if err != nil {
+		return // deleted
+	}
+	from := exportFrom
+	if !ad.lastT.IsZero() {
+		from = ad.lastT.Add(time.Nanosecond)
+	}
+	counts := m.Range(from, exportTo)
+	if len(counts) == 0 {
+		return
+	}
+	ad.lastT = counts[len(counts)-1].T
+
+	var upper, lower, anomaly []Count
+	for _, c := range counts {
+		mean, stddev, ok := ad.stats()
+		ad.observe(c.N)
+		if !ok {
+			continue
+		}
+		hi, lo := mean+ad.opts.Threshold*stddev, mean-ad.opts.Threshold*stddev
+		a := 0.0
+		if c.N > hi || c.N < lo {
+			a = 1
+		}
+		upper = append(upper, Count{N: hi, T: c.T})
+		lower = append(lower, Count{N: lo, T: c.T})
+		anomaly = append(anomaly, Count{N: a, T: c.T})
+	}
+	if len(upper) == 0 {
+		return
+	}
+	batch := map[string][]Count{
+		derivedTarget(ad.target, ".upper"):   upper,
+		derivedTarget(ad.target, ".lower"):   lower,
+		derivedTarget(ad.target, ".anomaly"): anomaly,
+	}
+	for t := range batch {
+		ad.d.srv.metrics.GetOrCreate(t, ad.size)
+	}
+	ad.d.srv.metrics.AddBatch(batch)
+}
+
+// stats returns the mean and standard deviation of the data points observed
+// so far, or false if there are fewer than two.
+func (ad *anomalyDetector) stats() (mean, stddev float64, ok bool) {
+	if ad.opts.Method == EWMA {
+		return ad.mean, math.Sqrt(ad.variance), ad.n >= 2
+	}
+	if len(ad.values) < 2 {
+		return 0, 0, false
+	}
+	for _, v := range ad.values {
+		mean += v
+	}
+	mean /= float64(len(ad.values))
+	for _, v := range ad.values {
+		stddev += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(stddev / float64(len(ad.values)-1)), true
+}
+
+// observe adds v to the statistics. It skips values that are not finite,
+// as a single NaN or Inf would spoil the statistics for good.
+func (ad *anomalyDetector) observe(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	if ad.opts.Method == EWMA {
+		ad.n++
+		if ad.n == 1 {
+			ad.mean = v
+			return
+		}
+		// See Finch, "Incremental calculation of weighted mean and variance".
+		alpha := 2 / (float64(ad.opts.Window) + 1)
+		diff := v - ad.mean
+		ad.mean += alpha * diff
+		ad.variance = (1 - alpha) * (ad.variance + alpha*diff*diff)
+		return
+	}
+	if len(ad.values) == ad.opts.Window {
+		ad.values = append(ad.values[:0], ad.values[1:]...)
+	}
+	ad.values = append(ad.values, v)
+}
+
+// derivedTarget appends suffix to the name in target, before the labels, if any.
+func derivedTarget(target, suffix string) string {
+	name, labels, ok := strings.Cut(target, "{")
+	if !ok {
+		return target + suffix
+	}
+	return name + suffix + "{" + labels
+}
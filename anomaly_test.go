@@ -0,0 +1,159 @@
+package grada
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestAnomalyDetector_update(t *testing.T) {
+	start := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Second) }
+
+	tests := []struct {
+		name        string
+		opts        *AnomalyOptions
+		values      []float64
+		wantUpper   []float64
+		wantAnomaly []float64
+	}{
+		{
+			name:        "zscore",
+			values:      []float64{10, 12, 10, 12, 40},
+			wantUpper:   []float64{11 + 3*math.Sqrt(2), 32.0/3 + 3*math.Sqrt(4.0/3), 11 + 3*math.Sqrt(4.0/3)},
+			wantAnomaly: []float64{0, 0, 1},
+		},
+		{
+			name:        "zscoreWindow",
+			opts:        &AnomalyOptions{Window: 2, Threshold: 1},
+			values:      []float64{0, 100, 10, 12},
+			wantUpper:   []float64{50 + math.Sqrt(5000), 55 + math.Sqrt(4050)},
+			wantAnomaly: []float64{0, 0},
+		},
+		{
+			name:        "ewma",
+			opts:        &AnomalyOptions{Method: EWMA, Window: 3},
+			values:      []float64{10, 10, 10, 20},
+			wantUpper:   []float64{10, 10},
+			wantAnomaly: []float64{0, 1},
+		},
+		{
+			name:   "tooFewPoints",
+			values: []float64{10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDashboard()
+			m, _ := d.CreateMetricWithBufSize("latency", 10)
+			ad := newAnomalyDetector(d, "latency", 10, tt.opts)
+			// Add the data points in two steps to check that
+			// update processes each data point only once.
+			half := len(tt.values) / 2
+			for i, v := range tt.values[:half] {
+				m.AddWithTime(v, at(i))
+			}
+			ad.update()
+			for i, v := range tt.values[half:] {
+				m.AddWithTime(v, at(half+i))
+			}
+			ad.update()
+
+			values := func(target string) []float64 {
+				dm, err := d.Metric(target)
+				if err != nil {
+					return nil
+				}
+				var vs []float64
+				for i, c := range dm.Range(exportFrom, exportTo) {
+					if !c.T.Equal(at(len(tt.values) - len(tt.wantUpper) + i)) {
+						t.Errorf("%s: data point %d has time %v", target, i, c.T)
+					}
+					vs = append(vs, c.N)
+				}
+				return vs
+			}
+			if diff := cmp.Diff(tt.wantUpper, values("latency.upper"), cmpopts.EquateApprox(0, 1e-9)); diff != "" {
+				t.Errorf("upper band (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tt.wantAnomaly, values("latency.anomaly")); diff != "" {
+				t.Errorf("anomalies (-want +got):\n%s", diff)
+			}
+			upper, lower := values("latency.upper"), values("latency.lower")
+			for i := range upper {
+				if lower[i] > upper[i] {
+					t.Errorf("lower band %f above upper band %f", lower[i], upper[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDerivedTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"latency", "latency.upper"},
+		{`latency{route="users"}`, `latency.upper{route="users"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if got := derivedTarget(tt.target, ".upper"); got != tt.want {
+				t.Errorf("derivedTarget() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDashboard_DetectAnomalies(t *testing.T) {
+	d := newTestDashboard()
+	defer close(d.shutdownChan())
+	if _, err := d.DetectAnomalies("missing", time.Second, nil); err == nil {
+		t.Error("DetectAnomalies() for a missing metric: want error")
+	}
+
+	m, _ := d.CreateMetricWithBufSize("latency", 10)
+	now := time.Now()
+	for i, v := range []float64{10, 12, 11, 50} {
+		m.AddWithTime(v, now.Add(time.Duration(i)*time.Millisecond))
+	}
+	stop, err := d.DetectAnomalies("latency", 5*time.Millisecond, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	deadline := time.Now().Add(time.Second)
+	for len(d.srv.metrics.Targets()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	want := []string{"latency", "latency.anomaly", "latency.lower", "latency.upper"}
+	if diff := cmp.Diff(want, d.srv.metrics.Targets()); diff != "" {
+		t.Errorf("targets (-want +got):\n%s", diff)
+	}
+}
+
+func TestAnomalyDetector_nonFinite(t *testing.T) {
+	start := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+	for _, method := range []AnomalyMethod{ZScore, EWMA} {
+		d := newTestDashboard()
+		m, _ := d.CreateMetricWithBufSize("latency", 10)
+		for i, v := range []float64{1, 2, math.NaN(), 1, math.Inf(1), 2, 100} {
+			m.AddWithTime(v, start.Add(time.Duration(i)*time.Second))
+		}
+		ad := newAnomalyDetector(d, "latency", 10, &AnomalyOptions{Method: method})
+		ad.update()
+
+		upper, _ := d.Metric("latency.upper")
+		anomaly, _ := d.Metric("latency.anomaly")
+		u, _ := upper.Last()
+		a, _ := anomaly.Last()
+		if math.IsNaN(u.N) || math.IsInf(u.N, 0) || a.N != 1 {
+			t.Errorf("method %d: got upper band %f and anomaly %f for 100, want a finite band and 1", method, u.N, a.N)
+		}
+	}
+}
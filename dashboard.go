@@ -46,6 +46,8 @@ Simply run
 package grada
 
 import (
+	"context"
+	"sync"
 	"time"
 )
 
@@ -54,18 +56,26 @@ import (
 // Start by creating a new dashboard through GetDashboard().
 //
 // Then create one or more metrics as needed using CreateMetric()
-// or CreateMetricWithBufSize().
+// or CreateMetricWithBufSize(), or their counterparts CreateCompressedMetric()
+// and CreateCompressedMetricWithBufSize() for metrics with many data points.
 //
 // Finally, have your code add data points to the metric by calling
 // Metric.Add() or Metric.AddWithTime().
+//
+// Besides Grafana, your own code can read the data points of a metric
+// through Metric.Range(), Metric.Last(), and Metric.Len().
 type Dashboard struct {
-	srv *server
+	srv      *server
+	mu       sync.Mutex     // protects shutdown
+	shutdown chan struct{}  // closed by Shutdown()
+	wg       sync.WaitGroup // counts the background goroutines
 }
 
 // GetDashboard initializes and/or returns the only existing dashboard.
 // This also starts the HTTP server that responds to queries from Grafana.
 // Default port is 3001. Overwrite this port by setting the environment
 // variable GRADA_PORT to the desired port number.
+// Call Shutdown() to stop the server.
 func GetDashboard() *Dashboard {
 	d := &Dashboard{}
 	d.srv = startServer()
@@ -92,7 +102,7 @@ func GetDashboard() *Dashboard {
 // Creating a metric for an existing target is an error. To replace a metric
 // (which is rarely needed), call DeleteMetric first.
 func (d *Dashboard) CreateMetric(target string, timeRange, interval time.Duration) (*Metric, error) {
-	return d.CreateMetricWithBufSize(target, d.bufSizeFor(timeRange, interval))
+	return d.CreateMetricWithBufSize(target, bufSizeFor(timeRange, interval))
 }
 
 // CreateMetricWithBufSize creates a new metric for the given target and with the
@@ -106,6 +116,7 @@ func (d *Dashboard) CreateMetric(target string, timeRange, interval time.Duratio
 //
 // Example: If the dashboards's time range is 5 minutes and the incoming data arrives every
 // second, the buffer should hold 300 item (5*60*1) at least.
+// To change the buffer size later on, call Metric.Resize().
 //
 // Creating a metric for an existing target is an error. To replace a metric
 // (which is rarely needed), call DeleteMetric first.
@@ -113,16 +124,155 @@ func (d *Dashboard) CreateMetricWithBufSize(target string, size int) (*Metric, e
 	return d.srv.metrics.Create(target, size)
 }
 
+// CreateCompressedMetric works like CreateMetric but creates a metric that stores
+// its data points in compressed form.
+//
+// Compressed metrics need only a fraction of the memory of a standard metric
+// if data points arrive at regular intervals and their values change slowly.
+// In turn, adding data points out of order and fetching data points for
+// Grafana take more time.
+//
+// Compressed metrics can only hold timestamps between the years 1678 and 2262,
+// the range of time.UnixNano(). They discard data points with other
+// timestamps, including the zero time.Time.
+func (d *Dashboard) CreateCompressedMetric(target string, timeRange, interval time.Duration) (*Metric, error) {
+	return d.CreateCompressedMetricWithBufSize(target, bufSizeFor(timeRange, interval))
+}
+
+// CreateCompressedMetricWithBufSize works like CreateMetricWithBufSize but creates
+// a metric that stores its data points in compressed form.
+// See CreateCompressedMetric().
+func (d *Dashboard) CreateCompressedMetricWithBufSize(target string, size int) (*Metric, error) {
+	return d.srv.metrics.CreateCompressed(target, size)
+}
+
+// GetOrCreateMetric returns the metric for the given target. If no such metric
+// exists, GetOrCreateMetric creates one, in the same way as CreateMetric().
+//
+// Use this method when several parts of your code write to the same target.
+// An existing metric keeps its buffer size; timeRange and interval only apply
+// to a new metric.
+func (d *Dashboard) GetOrCreateMetric(target string, timeRange, interval time.Duration) *Metric {
+	return d.srv.metrics.GetOrCreate(target, bufSizeFor(timeRange, interval))
+}
+
+// Metric returns the metric for the given target.
+// If no such metric exists, Metric returns an error.
+func (d *Dashboard) Metric(target string) (*Metric, error) {
+	return d.srv.metrics.Get(target)
+}
+
+// MetricInfo describes a metric of a dashboard. See Dashboard.Metrics().
+type MetricInfo struct {
+	Target     string
+	Size       int  // the buffer size
+	Len        int  // the number of data points in the buffer
+	Compressed bool // true if the metric stores its data points in compressed form
+}
+
+// Metrics returns a description of all metrics of the dashboard,
+// sorted by target name.
+func (d *Dashboard) Metrics() []MetricInfo {
+	var infos []MetricInfo
+	for _, target := range d.srv.metrics.Targets() {
+		mt, err := d.srv.metrics.Get(target)
+		if err != nil {
+			continue // deleted in the meantime
+		}
+		infos = append(infos, mt.info(target))
+	}
+	return infos
+}
+
 // bufSizeFor takes a duration and a rate (number of data points per second)
 // and returns the required ring buffer size.
-// Used by CreateMetric().
-func (d *Dashboard) bufSizeFor(timeRange, interval time.Duration) int {
+// Used by CreateMetric() and Metric.ResizeFor().
+func bufSizeFor(timeRange, interval time.Duration) int {
 	if interval.Nanoseconds() >= timeRange.Nanoseconds() {
 		return 1
 	}
 	return int(timeRange.Nanoseconds() / interval.Nanoseconds())
 }
 
+// AddBatch adds Counts to several metrics at once. The keys of batch are
+// the target names of the metrics.
+//
+// Queries from Grafana see either none or all of the new Counts.
+// If one of the metrics does not exist, AddBatch returns an error
+// and does not add any Counts.
+func (d *Dashboard) AddBatch(batch map[string][]Count) error {
+	return d.srv.metrics.AddBatch(batch)
+}
+
+// every calls f in a new goroutine every interval, with the time of the tick,
+// until f returns false, the returned stop function is called, or the
+// dashboard shuts down. stop waits for a running call of f to complete
+// and may be called more than once.
+func (d *Dashboard) every(interval time.Duration, f func(time.Time) bool) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	shutdown := d.shutdownChan()
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		defer close(stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-shutdown:
+				return
+			case t := <-ticker.C:
+				if !f(t) {
+					return
+				}
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+		})
+		<-stopped
+	}
+}
+
+// shutdownChan returns the channel that Shutdown closes.
+func (d *Dashboard) shutdownChan() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.shutdown == nil {
+		d.shutdown = make(chan struct{})
+	}
+	return d.shutdown
+}
+
+// Shutdown stops all background activity of the dashboard, such as samplers
+// and collectors, and then shuts down the HTTP server gracefully.
+// See http.Server.Shutdown() for the role of ctx.
+// Calling Shutdown more than once is a no-op.
+func (d *Dashboard) Shutdown(ctx context.Context) error {
+	shutdown := d.shutdownChan()
+	d.mu.Lock()
+	select {
+	case <-shutdown:
+		d.mu.Unlock()
+		return nil
+	default:
+		close(shutdown)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+
+	if d.srv.http == nil {
+		return nil
+	}
+	return d.srv.http.Shutdown(ctx)
+}
+
 // DeleteMetric deletes the metric for the given target from the server.
 func (d *Dashboard) DeleteMetric(target string) error {
 	return d.srv.metrics.Delete(target)
@@ -8,6 +8,15 @@ import (
 	"github.com/google/go-cmp/cmp"
 )
 
+// newTestDashboard returns a Dashboard without an HTTP server.
+func newTestDashboard() *Dashboard {
+	return &Dashboard{
+		srv: &server{
+			metrics: &metrics{metric: map[string]*Metric{}},
+		},
+	}
+}
+
 func TestDashboard_CreateMetricWithBufSize(t *testing.T) {
 	type args struct {
 		target string
@@ -47,9 +56,12 @@ func TestDashboard_CreateMetricWithBufSize(t *testing.T) {
 				t.Errorf("Server.CreateMetric() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr {
+				return
+			}
 			want := d.srv.metrics.metric[tt.args.target]
-			if !cmp.Equal(got, want, cmp.AllowUnexported((*got), (*got).m)) {
-				t.Errorf("Server.CreateMetric():\ngot  %v\nwant %v\ndiff:\n%s", got, want, cmp.Diff(got, want, cmp.AllowUnexported(*got, (*got).m)))
+			if got != want { // strict identity required
+				t.Errorf("Server.CreateMetric():\ngot  %p\nwant %p", got, want)
 			}
 		})
 	}
@@ -72,7 +84,7 @@ func TestGetDashboard(t *testing.T) {
 	}
 }
 
-func TestDashboard_bufSizeFor(t *testing.T) {
+func Test_bufSizeFor(t *testing.T) {
 	tests := []struct {
 		name                string
 		timeRange, interval time.Duration
@@ -85,10 +97,41 @@ func TestDashboard_bufSizeFor(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			d := &Dashboard{}
-			if got := d.bufSizeFor(tt.timeRange, tt.interval); got != tt.want {
-				t.Errorf("Dashboard.For() = %v, want %v", got, tt.want)
+			if got := bufSizeFor(tt.timeRange, tt.interval); got != tt.want {
+				t.Errorf("bufSizeFor() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestDashboard_Metrics(t *testing.T) {
+	d := newTestDashboard()
+	if got := d.Metrics(); len(got) != 0 {
+		t.Errorf("Dashboard.Metrics() of empty dashboard: got %v", got)
+	}
+
+	m1, _ := d.CreateMetricWithBufSize("target2", 10)
+	m1.Add(1)
+	m1.Add(2)
+	d.CreateCompressedMetricWithBufSize("target1", 20)
+	m2 := d.GetOrCreateMetric("target3", time.Minute, time.Second)
+	if got := d.GetOrCreateMetric("target3", time.Hour, time.Second); got != m2 {
+		t.Errorf("Dashboard.GetOrCreateMetric(): got %p, want existing metric %p", got, m2)
+	}
+
+	if got, err := d.Metric("target2"); err != nil || got != m1 {
+		t.Errorf("Dashboard.Metric(): got %p, %v, want %p", got, err, m1)
+	}
+	if _, err := d.Metric("target4"); err == nil {
+		t.Errorf("Dashboard.Metric() for non-existing target: want error")
+	}
+
+	want := []MetricInfo{
+		{Target: "target1", Size: 20, Len: 0, Compressed: true},
+		{Target: "target2", Size: 10, Len: 2, Compressed: false},
+		{Target: "target3", Size: 60, Len: 0, Compressed: false},
+	}
+	if got := d.Metrics(); !cmp.Equal(got, want) {
+		t.Errorf("Dashboard.Metrics():\ngot  %v\nwant %v", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package grada
+
+// A collector for the connection pool statistics of a database/sql database.
+
+import (
+	"database/sql"
+	"time"
+)
+
+// dbStatsCollector samples sql.DBStats and adds the values to grada metrics.
+type dbStatsCollector struct {
+	d      *Dashboard
+	size   int
+	db     *sql.DB
+	prefix string
+
+	// The previous sample, for rates
+	prevT     time.Time
+	prevStats sql.DBStats
+}
+
+// CollectDBStats starts collecting the connection pool statistics of db
+// every interval. It creates the following metrics, with each target name
+// starting with prefix:
+//
+//	open          number of established connections, in use or idle
+//	inuse         number of connections in use
+//	idle          number of idle connections
+//	max.open      maximum number of open connections (0 means unlimited)
+//	waits         number of waits for a connection per second
+//	wait.time     time spent waiting for a connection, in seconds per second
+//
+// A pool that runs out of connections shows up as inuse reaching max.open,
+// along with a rising number of waits. wait.time is the average number of
+// goroutines that were waiting for a connection during the interval.
+//
+// The rates refer to the period since the previous sample; therefore,
+// they get recorded from the second sample on.
+// timeRange and interval determine the buffer size of the metrics,
+// see CreateMetric(). Existing metrics of the same name are reused.
+//
+// Collecting stops when the returned function is called,
+// or when the dashboard shuts down.
+func (d *Dashboard) CollectDBStats(db *sql.DB, prefix string, timeRange, interval time.Duration) (stop func()) {
+	c := &dbStatsCollector{
+		d:      d,
+		size:   bufSizeFor(timeRange, interval),
+		db:     db,
+		prefix: prefix,
+	}
+	c.collect(time.Now())
+	return d.every(interval, func(t time.Time) bool {
+		c.collect(t)
+		return true
+	})
+}
+
+// collect reads the statistics of the database and adds them to the
+// grada metrics with timestamp now.
+func (c *dbStatsCollector) collect(now time.Time) {
+	s := c.db.Stats()
+	c.gauge("open", float64(s.OpenConnections), now)
+	c.gauge("inuse", float64(s.InUse), now)
+	c.gauge("idle", float64(s.Idle), now)
+	c.gauge("max.open", float64(s.MaxOpenConnections), now)
+	if !c.prevT.IsZero() {
+		secs := now.Sub(c.prevT).Seconds()
+		c.gauge("waits", float64(s.WaitCount-c.prevStats.WaitCount)/secs, now)
+		c.gauge("wait.time", (s.WaitDuration-c.prevStats.WaitDuration).Seconds()/secs, now)
+	}
+	c.prevT, c.prevStats = now, s
+}
+
+// gauge adds v to the target.
+func (c *dbStatsCollector) gauge(target string, v float64, now time.Time) {
+	c.d.srv.metrics.GetOrCreate(c.prefix+target, c.size).AddWithTime(v, now)
+}
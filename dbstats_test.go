@@ -0,0 +1,95 @@
+package grada
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testDriver is a database/sql driver whose connections cannot do anything
+// but get opened and closed.
+type testDriver struct{}
+
+func (testDriver) Open(name string) (driver.Conn, error) { return testConn{}, nil }
+
+type testConn struct{}
+
+func (testConn) Prepare(query string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (testConn) Close() error                              { return nil }
+func (testConn) Begin() (driver.Tx, error)                 { return nil, errors.New("not supported") }
+
+func init() {
+	sql.Register("grada-test", testDriver{})
+}
+
+func TestDashboard_CollectDBStats(t *testing.T) {
+	db, err := sql.Open("grada-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(2)
+	ctx := context.Background()
+	conn1, _ := db.Conn(ctx)
+	conn2, _ := db.Conn(ctx)
+	conn2.Close()
+
+	// Take the first sample before anything waits for a connection,
+	// so that nothing can take conn2 in the meantime.
+	d := newTestDashboard()
+	c := &dbStatsCollector{d: d, size: 10, db: db, prefix: "db."}
+	start := time.Now()
+	c.collect(start)
+
+	// Wait for a connection until conn1 gets released.
+	waited := make(chan struct{})
+	go func() {
+		conn2, _ := db.Conn(ctx)
+		conn3, _ := db.Conn(ctx)
+		conn2.Close()
+		conn3.Close()
+		close(waited)
+	}()
+
+	for db.Stats().WaitCount == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	conn1.Close()
+	<-waited
+	c.collect(start.Add(time.Second))
+
+	tests := []struct {
+		target string
+		want   []float64
+	}{
+		{"db.open", []float64{2, 2}},
+		{"db.inuse", []float64{1, 0}},
+		{"db.idle", []float64{1, 2}},
+		{"db.max.open", []float64{2, 2}},
+		{"db.waits", []float64{1}},
+	}
+	for _, tt := range tests {
+		m, err := d.Metric(tt.target)
+		if err != nil {
+			t.Errorf("%s: %v", tt.target, err)
+			continue
+		}
+		got := m.Range(start, start.Add(time.Second))
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.target, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i].N != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.target, got, tt.want)
+				break
+			}
+		}
+	}
+	if m, err := d.Metric("db.wait.time"); err != nil || m.Len() != 1 {
+		t.Errorf("db.wait.time: got %v, want one data point", err)
+	}
+}
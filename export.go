@@ -0,0 +1,122 @@
+package grada
+
+// An HTTP endpoint for exporting the raw data points of metrics.
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The earliest and latest times that both stores can handle,
+// as the default range of an export.
+var (
+	exportFrom = time.Unix(0, math.MinInt64+1)
+	exportTo   = time.Unix(0, math.MaxInt64-1)
+)
+
+// exportPoint is a line of a JSON Lines export. The fields match
+// the data points that WriteHandler() accepts.
+type exportPoint struct {
+	Target    string  `json:"target"`
+	Value     float64 `json:"value"`
+	Timestamp string  `json:"timestamp"`
+}
+
+// ExportHandler returns an HTTP handler that exports the data points of
+// one or more metrics at full resolution, for example into a spreadsheet
+// or a notebook. Unlike the responses to Grafana, the export neither
+// truncates timestamps to milliseconds nor thins out the data points.
+//
+// As the Grafana API server serves http.DefaultServeMux, the handler can be
+// added to this server by
+//
+//	http.Handle("/export", d.ExportHandler())
+//
+// The handler understands the following query parameters:
+//
+//	target    the target name of a metric; repeat the parameter
+//	          to export several metrics
+//	from, to  the time range to export (inclusive), either as milliseconds
+//	          since the Unix epoch or in RFC 3339 format; by default,
+//	          the handler exports all data points
+//	format    "csv" (the default) or "jsonl"
+//
+// A CSV export starts with the header line "target,timestamp,value".
+// A JSON Lines export contains one JSON object per line:
+//
+//	{"target":"queue.length","value":42,"timestamp":"2017-10-25T11:16:54.123456789Z"}
+//
+// In both formats, timestamps are in RFC 3339 format with nanoseconds, and
+// the data points of each metric are in time order. As JSON has no notation
+// for them, JSON Lines exports skip NaN and infinite values.
+// Unknown targets or invalid parameters yield "400 Bad Request".
+func (d *Dashboard) ExportHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		targets := q["target"]
+		if len(targets) == 0 {
+			writeError(w, errors.New("no target"), "cannot export")
+			return
+		}
+		from, to := exportFrom, exportTo
+		var err error
+		if s := q.Get("from"); s != "" {
+			if from, err = parseTime(s); err != nil {
+				writeError(w, err, "invalid parameter from")
+				return
+			}
+		}
+		if s := q.Get("to"); s != "" {
+			if to, err = parseTime(s); err != nil {
+				writeError(w, err, "invalid parameter to")
+				return
+			}
+		}
+		format := q.Get("format")
+		if format != "" && format != "csv" && format != "jsonl" {
+			writeError(w, errors.New("unknown format "+format), "invalid parameter format")
+			return
+		}
+
+		// Fetch all metrics before writing, so that an unknown target
+		// still gets a proper error response.
+		mts := make([]*Metric, len(targets))
+		for i, target := range targets {
+			if mts[i], err = d.srv.metrics.Get(target); err != nil {
+				writeError(w, err, "cannot export")
+				return
+			}
+		}
+
+		bw := bufio.NewWriter(w)
+		defer bw.Flush()
+		if format == "jsonl" {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			enc := json.NewEncoder(bw)
+			for i, mt := range mts {
+				for _, c := range mt.Range(from, to) {
+					if math.IsNaN(c.N) || math.IsInf(c.N, 0) {
+						continue
+					}
+					enc.Encode(exportPoint{targets[i], c.N, c.T.Format(time.RFC3339Nano)})
+				}
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(bw)
+		cw.Write([]string{"target", "timestamp", "value"})
+		for i, mt := range mts {
+			for _, c := range mt.Range(from, to) {
+				cw.Write([]string{targets[i], c.T.Format(time.RFC3339Nano), strconv.FormatFloat(c.N, 'g', -1, 64)})
+			}
+		}
+		cw.Flush()
+	})
+}
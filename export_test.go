@@ -0,0 +1,63 @@
+package grada
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDashboard_ExportHandler(t *testing.T) {
+	d := newTestDashboard()
+	start := time.Date(2017, time.October, 25, 11, 16, 54, 123456789, time.UTC)
+	a, _ := d.CreateMetricWithBufSize("a", 10)
+	b, _ := d.CreateMetricWithBufSize("b,c", 10)
+	for i := 0; i < 3; i++ {
+		a.AddWithTime(float64(i), start.Add(time.Duration(i)*time.Second))
+	}
+	b.AddWithTime(math.NaN(), start)
+	b.AddWithTime(0.5, start.Add(time.Nanosecond))
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       string
+	}{
+		{
+			"csv",
+			"target=a&target=b,c",
+			http.StatusOK,
+			"target,timestamp,value\n" +
+				"a,2017-10-25T11:16:54.123456789Z,0\n" +
+				"a,2017-10-25T11:16:55.123456789Z,1\n" +
+				"a,2017-10-25T11:16:56.123456789Z,2\n" +
+				"\"b,c\",2017-10-25T11:16:54.123456789Z,NaN\n" +
+				"\"b,c\",2017-10-25T11:16:54.12345679Z,0.5\n",
+		},
+		{
+			"jsonlRange",
+			"target=a&target=b,c&format=jsonl&from=2017-10-25T11:16:54.123456790Z&to=1508930215124",
+			http.StatusOK,
+			`{"target":"a","value":1,"timestamp":"2017-10-25T11:16:55.123456789Z"}` + "\n" +
+				`{"target":"b,c","value":0.5,"timestamp":"2017-10-25T11:16:54.12345679Z"}` + "\n",
+		},
+		{"noTarget", "", http.StatusBadRequest, ""},
+		{"unknownTarget", "target=a&target=x", http.StatusBadRequest, ""},
+		{"invalidFrom", "target=a&from=yesterday", http.StatusBadRequest, ""},
+		{"invalidFormat", "target=a&format=xml", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			d.ExportHandler().ServeHTTP(w, httptest.NewRequest("GET", "/export?"+tt.query, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.want != "" && w.Body.String() != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", w.Body, tt.want)
+			}
+		})
+	}
+}
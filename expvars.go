@@ -0,0 +1,83 @@
+package grada
+
+// A bridge from package expvar to grada metrics.
+
+import (
+	"encoding/json"
+	"expvar"
+	"time"
+)
+
+// expvarCollector samples all published expvar variables
+// and adds the numeric ones to grada metrics.
+type expvarCollector struct {
+	d      *Dashboard
+	size   int
+	prefix string
+}
+
+// CollectExpvars starts publishing all numeric expvar variables as metrics,
+// reading them every interval.
+//
+// The target name of a metric is the name of the variable, preceded by prefix.
+// Variables whose value is a JSON object, like expvar.Map or "memstats",
+// become one metric per numeric field, with the field names appended to the
+// variable name, separated by dots. For example, with prefix "expvar.", the
+// field NumGC of the "memstats" variable goes to target "expvar.memstats.NumGC".
+// Strings, booleans, and arrays are skipped.
+//
+// timeRange and interval determine the buffer size of the metrics,
+// see CreateMetric(). Existing metrics of the same name are reused.
+//
+// Collecting stops when the returned function is called,
+// or when the dashboard shuts down.
+func (d *Dashboard) CollectExpvars(prefix string, timeRange, interval time.Duration) (stop func()) {
+	c := &expvarCollector{
+		d:      d,
+		size:   bufSizeFor(timeRange, interval),
+		prefix: prefix,
+	}
+	c.collect(time.Now())
+	return d.every(interval, func(t time.Time) bool {
+		c.collect(t)
+		return true
+	})
+}
+
+// collect reads all expvar variables and adds their numeric values
+// to the grada metrics with timestamp now.
+func (c *expvarCollector) collect(now time.Time) {
+	expvar.Do(func(kv expvar.KeyValue) {
+		switch v := kv.Value.(type) {
+		case *expvar.Int:
+			c.add(kv.Key, float64(v.Value()), now)
+		case *expvar.Float:
+			c.add(kv.Key, v.Value(), now)
+		default:
+			// All other variables, including expvar.Map and expvar.Func,
+			// have a JSON representation.
+			var value interface{}
+			if err := json.Unmarshal([]byte(v.String()), &value); err != nil {
+				return
+			}
+			c.addJSON(kv.Key, value, now)
+		}
+	})
+}
+
+// addJSON adds value to target if value is a number, or adds the fields
+// of value to target.<field name> if value is a JSON object.
+func (c *expvarCollector) addJSON(target string, value interface{}, now time.Time) {
+	switch v := value.(type) {
+	case float64:
+		c.add(target, v, now)
+	case map[string]interface{}:
+		for key, field := range v {
+			c.addJSON(target+"."+key, field, now)
+		}
+	}
+}
+
+func (c *expvarCollector) add(target string, v float64, now time.Time) {
+	c.d.srv.metrics.GetOrCreate(c.prefix+target, c.size).AddWithTime(v, now)
+}
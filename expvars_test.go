@@ -0,0 +1,93 @@
+package grada
+
+import (
+	"expvar"
+	"testing"
+	"time"
+)
+
+// The test variables get published once, as expvar does not allow
+// publishing a name twice, for example with go test -count=2.
+var (
+	testExpvarInt     = expvar.NewInt("grada.test.int")
+	testExpvarFloat   = expvar.NewFloat("grada.test.float")
+	testExpvarString  = expvar.NewString("grada.test.string")
+	testExpvarMap     = expvar.NewMap("grada.test.map")
+	testExpvarCounter = expvar.NewInt("grada.test.counter")
+)
+
+func init() {
+	expvar.Publish("grada.test.func", expvar.Func(func() interface{} {
+		return map[string]interface{}{"open": 2, "names": []string{"a", "b"}}
+	}))
+}
+
+func TestExpvarCollector_collect(t *testing.T) {
+	testExpvarInt.Set(42)
+	testExpvarFloat.Set(1.5)
+	testExpvarString.Set("not a number")
+	m := testExpvarMap.Init()
+	m.Add("requests", 7)
+	m.AddFloat("latency", 0.25)
+	nested := new(expvar.Map).Init()
+	nested.Add("hits", 3)
+	m.Set("cache", nested)
+
+	d := newTestDashboard()
+	c := &expvarCollector{d: d, size: 10, prefix: "ev."}
+	c.collect(time.Now())
+
+	tests := []struct {
+		target string
+		want   float64
+	}{
+		{"ev.grada.test.int", 42},
+		{"ev.grada.test.float", 1.5},
+		{"ev.grada.test.map.requests", 7},
+		{"ev.grada.test.map.latency", 0.25},
+		{"ev.grada.test.map.cache.hits", 3},
+		{"ev.grada.test.func.open", 2},
+		{"ev.memstats.NumGC", -1}, // any value
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			m, err := d.Metric(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, ok := m.Last()
+			if !ok {
+				t.Fatalf("no data points")
+			}
+			if tt.want >= 0 && c.N != tt.want {
+				t.Errorf("got %f, want %f", c.N, tt.want)
+			}
+		})
+	}
+
+	for _, target := range []string{"ev.grada.test.string", "ev.grada.test.func.names", "ev.cmdline"} {
+		if _, err := d.Metric(target); err == nil {
+			t.Errorf("non-numeric variable %s became a metric", target)
+		}
+	}
+}
+
+func TestDashboard_CollectExpvars(t *testing.T) {
+	d := newTestDashboard()
+	v := testExpvarCounter
+	v.Set(0)
+	stop := d.CollectExpvars("", time.Minute, 5*time.Millisecond)
+	for i := 0; i < 5; i++ {
+		v.Add(1)
+		time.Sleep(5 * time.Millisecond)
+	}
+	stop()
+
+	m, err := d.Metric("grada.test.counter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() < 2 {
+		t.Errorf("CollectExpvars(): got %d data points, want 2 or more", m.Len())
+	}
+}
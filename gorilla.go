@@ -0,0 +1,445 @@
+package grada
+
+// A compressed store for Metric data, based on the encoding described in
+// "Gorilla: A Fast, Scalable, In-Memory Time Series Database"
+// (http://www.vldb.org/pvldb/vol8/p1816-teller.pdf):
+//
+// * Timestamps are stored as delta-of-deltas.
+// * Values are stored as the XOR of the previous value.
+//
+// Both encodings take only a few bits per data point if the data arrives
+// at regular intervals and changes slowly. The data points are stored
+// in chunks of a fixed number of points. Only the last chunk grows;
+// the other chunks are only re-encoded if a data point arrives out of order.
+//
+// Timestamps are stored with nanosecond precision but without location
+// and without monotonic clock reading. They must lie within the range
+// of time.UnixNano(), that is, between the years 1678 and 2262.
+// The store discards Counts with timestamps outside this range.
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+)
+
+// chunkSize is the number of data points per chunk.
+const chunkSize = 120
+
+// The range of timestamps that a gorilla store can hold.
+var (
+	minGorillaTime = time.Unix(0, math.MinInt64)
+	maxGorillaTime = time.Unix(0, math.MaxInt64)
+)
+
+// inGorillaRange reports whether t lies within the range of time.UnixNano().
+func inGorillaRange(t time.Time) bool {
+	return !t.Before(minGorillaTime) && !t.After(maxGorillaTime)
+}
+
+// ## Bit stream
+
+// bstream is an append-only stream of bits.
+type bstream struct {
+	b []byte
+	n uint // number of bits written
+}
+
+func (s *bstream) writeBit(bit bool) {
+	if s.n%8 == 0 {
+		s.b = append(s.b, 0)
+	}
+	if bit {
+		s.b[len(s.b)-1] |= 1 << (7 - s.n%8)
+	}
+	s.n++
+}
+
+// writeBits writes the nbits least significant bits of v, most significant bit first.
+func (s *bstream) writeBits(v uint64, nbits int) {
+	for i := nbits - 1; i >= 0; i-- {
+		s.writeBit(v&(1<<uint(i)) != 0)
+	}
+}
+
+// breader reads a bstream.
+type breader struct {
+	b   []byte
+	pos uint
+}
+
+func (r *breader) readBit() bool {
+	bit := r.b[r.pos/8]&(1<<(7-r.pos%8)) != 0
+	r.pos++
+	return bit
+}
+
+func (r *breader) readBits(nbits int) uint64 {
+	var v uint64
+	for i := 0; i < nbits; i++ {
+		v <<= 1
+		if r.readBit() {
+			v |= 1
+		}
+	}
+	return v
+}
+
+// ## Chunks
+
+// dodBuckets are the bit sizes for encoding a delta-of-delta.
+// A delta-of-delta of zero takes a single '0' bit. Otherwise, the number of
+// leading '1' bits selects the bucket, followed by a '0' bit (except for
+// the last bucket) and the value. The buckets are larger than in the
+// Gorilla paper because timestamps have nanosecond resolution.
+var dodBuckets = []int{14, 24, 36, 64}
+
+// chunk is a compressed sequence of data points in timestamp order.
+type chunk struct {
+	bs    bstream
+	count int // number of encoded data points
+	skip  int // number of leading data points that have been evicted
+	minT  int64
+	maxT  int64
+
+	// Encoder state for appending more data points
+	t        int64
+	delta    int64
+	v        uint64
+	leading  int
+	trailing int
+}
+
+// fits reports whether x can be stored as a two's complement number of nbits bits.
+func fits(x int64, nbits int) bool {
+	if nbits == 64 {
+		return true
+	}
+	return x >= -(1<<(nbits-1)) && x < 1<<(nbits-1)
+}
+
+// append adds a data point to the chunk. t must not be less than c.maxT.
+func (c *chunk) append(t int64, n float64) {
+	v := math.Float64bits(n)
+	if c.count == 0 {
+		c.bs.writeBits(uint64(t), 64)
+		c.bs.writeBits(v, 64)
+		c.minT = t
+		c.leading = -1
+	} else {
+		delta := t - c.t
+		c.writeDod(delta - c.delta)
+		c.delta = delta
+		c.writeXor(v ^ c.v)
+	}
+	c.t = t
+	c.v = v
+	c.maxT = t
+	c.count++
+}
+
+func (c *chunk) writeDod(dod int64) {
+	if dod == 0 {
+		c.bs.writeBit(false)
+		return
+	}
+	for i, nbits := range dodBuckets {
+		if !fits(dod, nbits) {
+			continue
+		}
+		c.bs.writeBit(true)
+		if i < len(dodBuckets)-1 {
+			c.bs.writeBits(1<<uint(i+1)-2, i+1) // i more '1' bits followed by a '0'
+		} else {
+			c.bs.writeBits(1<<uint(i)-1, i)
+		}
+		c.bs.writeBits(uint64(dod), nbits)
+		return
+	}
+}
+
+func (c *chunk) writeXor(x uint64) {
+	if x == 0 {
+		c.bs.writeBit(false)
+		return
+	}
+	c.bs.writeBit(true)
+
+	leading := bits.LeadingZeros64(x)
+	trailing := bits.TrailingZeros64(x)
+	if leading > 31 {
+		leading = 31 // must fit into 5 bits
+	}
+
+	// Reuse the previous window of meaningful bits if x fits into it.
+	if c.leading >= 0 && leading >= c.leading && trailing >= c.trailing {
+		c.bs.writeBit(false)
+		c.bs.writeBits(x>>uint(c.trailing), 64-c.leading-c.trailing)
+		return
+	}
+
+	sig := 64 - leading - trailing
+	c.bs.writeBit(true)
+	c.bs.writeBits(uint64(leading), 5)
+	c.bs.writeBits(uint64(sig), 6) // 64 overflows to 0
+	c.bs.writeBits(x>>uint(trailing), sig)
+	c.leading = leading
+	c.trailing = trailing
+}
+
+// chunkIter decodes the data points of a chunk.
+type chunkIter struct {
+	r        breader
+	i, count int
+	t        int64
+	delta    int64
+	v        uint64
+	leading  int
+	trailing int
+}
+
+func (c *chunk) iter() *chunkIter {
+	return &chunkIter{
+		r:     breader{b: c.bs.b},
+		count: c.count,
+	}
+}
+
+// next returns the next data point of the chunk, or false if there are
+// no more data points.
+func (it *chunkIter) next() (Count, bool) {
+	if it.i >= it.count {
+		return Count{}, false
+	}
+	if it.i == 0 {
+		it.t = int64(it.r.readBits(64))
+		it.v = it.r.readBits(64)
+	} else {
+		it.delta += it.readDod()
+		it.t += it.delta
+		it.v ^= it.readXor()
+	}
+	it.i++
+	return Count{N: math.Float64frombits(it.v), T: time.Unix(0, it.t)}, true
+}
+
+func (it *chunkIter) readDod() int64 {
+	if !it.r.readBit() {
+		return 0
+	}
+	i := 0
+	for i < len(dodBuckets)-1 && it.r.readBit() {
+		i++
+	}
+	nbits := dodBuckets[i]
+	v := it.r.readBits(nbits)
+	if nbits < 64 && v&(1<<uint(nbits-1)) != 0 {
+		v |= math.MaxUint64 << uint(nbits) // sign extension
+	}
+	return int64(v)
+}
+
+func (it *chunkIter) readXor() uint64 {
+	if !it.r.readBit() {
+		return 0
+	}
+	if it.r.readBit() {
+		it.leading = int(it.r.readBits(5))
+		sig := int(it.r.readBits(6))
+		if sig == 0 {
+			sig = 64
+		}
+		it.trailing = 64 - it.leading - sig
+	}
+	return it.r.readBits(64-it.leading-it.trailing) << uint(it.trailing)
+}
+
+// counts returns the data points of the chunk that have not been evicted.
+func (c *chunk) counts() []Count {
+	counts := make([]Count, 0, c.count)
+	it := c.iter()
+	for count, ok := it.next(); ok; count, ok = it.next() {
+		counts = append(counts, count)
+	}
+	return counts[c.skip:]
+}
+
+// ## The compressed store
+
+// gorilla is a store that keeps its Counts in compressed chunks.
+type gorilla struct {
+	chunks    []*chunk
+	n         int
+	capacity  int
+	chunkSize int
+}
+
+// newGorilla creates a compressed store that holds up to size Counts.
+func newGorilla(size int) *gorilla {
+	return &gorilla{
+		capacity:  size,
+		chunkSize: min(chunkSize, size),
+	}
+}
+
+func (g *gorilla) len() int {
+	return g.n
+}
+
+func (g *gorilla) size() int {
+	return g.capacity
+}
+
+func (g *gorilla) last() (Count, bool) {
+	if g.n == 0 {
+		return Count{}, false
+	}
+	counts := g.chunks[len(g.chunks)-1].counts()
+	return counts[len(counts)-1], true
+}
+
+// resize changes the capacity of the store to size,
+// evicting the oldest Counts if the store holds more than size Counts.
+func (g *gorilla) resize(size int) {
+	g.capacity = size
+	g.chunkSize = min(chunkSize, size)
+	g.evict()
+}
+
+// insert adds c at the position determined by its timestamp.
+// Counts with identical timestamps remain in the order of arrival.
+// insert discards c if its timestamp is out of range.
+func (g *gorilla) insert(c Count) {
+	if g.capacity == 0 || !inGorillaRange(c.T) {
+		return
+	}
+	t := c.T.UnixNano()
+
+	// Fast path: c is newer than all Counts in the store.
+	if g.n == 0 || t >= g.chunks[len(g.chunks)-1].maxT {
+		if len(g.chunks) == 0 || g.chunks[len(g.chunks)-1].count >= g.chunkSize {
+			g.chunks = append(g.chunks, &chunk{})
+		}
+		g.chunks[len(g.chunks)-1].append(t, c.N)
+		g.n++
+		g.evict()
+		return
+	}
+
+	// Find the last chunk that starts at or before t.
+	i := sort.Search(len(g.chunks), func(i int) bool {
+		return g.chunks[i].minT > t
+	}) - 1
+	if i < 0 {
+		i = 0
+	}
+	counts := g.chunks[i].counts()
+	pos := sort.Search(len(counts), func(j int) bool {
+		return counts[j].T.UnixNano() > t
+	})
+	if i == 0 && pos == 0 && g.n == g.capacity {
+		// c is the oldest Count of all, hence it is the one to evict.
+		return
+	}
+	counts = append(counts, Count{})
+	copy(counts[pos+1:], counts[pos:])
+	counts[pos] = Count{N: c.N, T: time.Unix(0, t)}
+
+	// Re-encode the chunk, and split it in two if it grew too large.
+	var encoded []*chunk
+	if len(counts) <= g.chunkSize {
+		encoded = []*chunk{encode(counts)}
+	} else {
+		encoded = []*chunk{encode(counts[:len(counts)/2]), encode(counts[len(counts)/2:])}
+	}
+	g.chunks = append(g.chunks[:i], append(encoded, g.chunks[i+1:]...)...)
+	g.n++
+	g.evict()
+}
+
+// insertSorted inserts counts, which must be in time order.
+// All chunks that contain Counts newer than the first one are decoded,
+// merged with counts, and encoded again. Counts with timestamps out of
+// range get discarded; as counts are sorted, they can only be at either end.
+func (g *gorilla) insertSorted(counts []Count) {
+	for len(counts) > 0 && !inGorillaRange(counts[0].T) {
+		counts = counts[1:]
+	}
+	for len(counts) > 0 && !inGorillaRange(counts[len(counts)-1].T) {
+		counts = counts[:len(counts)-1]
+	}
+	if g.capacity == 0 || len(counts) == 0 {
+		return
+	}
+	t := counts[0].T.UnixNano()
+
+	var tail []Count
+	if g.n > 0 && t < g.chunks[len(g.chunks)-1].maxT {
+		i := sort.Search(len(g.chunks), func(i int) bool {
+			return g.chunks[i].minT > t
+		}) - 1
+		if i < 0 {
+			i = 0
+		}
+		for _, c := range g.chunks[i:] {
+			tail = append(tail, c.counts()...)
+		}
+		g.n -= len(tail)
+		g.chunks = g.chunks[:i]
+	}
+
+	for _, c := range merge(tail, counts) {
+		if len(g.chunks) == 0 || g.chunks[len(g.chunks)-1].count >= g.chunkSize {
+			g.chunks = append(g.chunks, &chunk{})
+		}
+		g.chunks[len(g.chunks)-1].append(c.T.UnixNano(), c.N)
+		g.n++
+	}
+	g.evict()
+}
+
+// encode creates a new chunk from counts.
+func encode(counts []Count) *chunk {
+	c := &chunk{}
+	for _, count := range counts {
+		c.append(count.T.UnixNano(), count.N)
+	}
+	return c
+}
+
+// evict removes the oldest Counts until the store does not exceed its capacity.
+func (g *gorilla) evict() {
+	for g.n > g.capacity {
+		first := g.chunks[0]
+		first.skip++
+		g.n--
+		if first.skip == first.count {
+			g.chunks[0] = nil
+			g.chunks = g.chunks[1:]
+		}
+	}
+}
+
+// appendRange appends all Counts with from < T < to to dst.
+func (g *gorilla) appendRange(dst []Count, from, to time.Time) []Count {
+	for _, c := range g.chunks {
+		if !time.Unix(0, c.maxT).After(from) {
+			continue
+		}
+		if !time.Unix(0, c.minT).Before(to) {
+			break
+		}
+		it := c.iter()
+		for i := 0; ; i++ {
+			count, ok := it.next()
+			if !ok || !count.T.Before(to) {
+				break
+			}
+			if i >= c.skip && count.T.After(from) {
+				dst = append(dst, count)
+			}
+		}
+	}
+	return dst
+}
@@ -0,0 +1,297 @@
+package grada
+
+import (
+	"math"
+	"math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	minTime = time.Unix(0, math.MinInt64)
+	maxTime = time.Unix(0, math.MaxInt64)
+)
+
+func TestChunk_roundtrip(t *testing.T) {
+	start := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC).UnixNano()
+
+	tests := []struct {
+		name  string
+		times []int64
+		vals  []float64
+	}{
+		{
+			"regular",
+			[]int64{start, start + 1e9, start + 2e9, start + 3e9},
+			[]float64{1, 1, 1, 1},
+		},
+		{
+			"jitter",
+			[]int64{start, start + 1e9 + 17, start + 2e9 - 3000, start + 3e9 + 5e6, start + 4e9 + 9e9},
+			[]float64{0.5, -12.25, 1e300, -1e-300, 42},
+		},
+		{
+			"duplicateTimestamps",
+			[]int64{start, start, start + 10, start + 10, start + 10},
+			[]float64{1, 2, 3, 4, 5},
+		},
+		{
+			"extremes",
+			[]int64{math.MinInt64, 0, math.MaxInt64},
+			[]float64{math.Inf(-1), math.Copysign(0, -1), math.Inf(1)},
+		},
+		{
+			"largeGaps",
+			[]int64{start, start + int64(time.Hour), start + int64(time.Hour) + 1, start + int64(100*time.Hour)},
+			[]float64{math.MaxFloat64, math.SmallestNonzeroFloat64, 3, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &chunk{}
+			want := []Count{}
+			for i := range tt.times {
+				c.append(tt.times[i], tt.vals[i])
+				want = append(want, Count{N: tt.vals[i], T: time.Unix(0, tt.times[i])})
+			}
+			got := c.counts()
+			if !cmp.Equal(got, want) {
+				t.Errorf("chunk roundtrip:\ngot  %v\nwant %v\ndiff:\n%s", got, want, cmp.Diff(got, want))
+			}
+		})
+	}
+}
+
+func TestChunk_roundtripNaN(t *testing.T) {
+	c := &chunk{}
+	c.append(1, math.NaN())
+	c.append(2, 1)
+	c.append(3, math.NaN())
+	got := c.counts()
+	if len(got) != 3 || !math.IsNaN(got[0].N) || got[1].N != 1 || !math.IsNaN(got[2].N) {
+		t.Errorf("chunk roundtrip with NaN: got %v", got)
+	}
+}
+
+// TestGorilla_insert compares the compressed store with the ring buffer,
+// which serves as the reference implementation.
+func TestGorilla_insert(t *testing.T) {
+	start := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		size       int
+		points     int
+		outOfOrder float64 // fraction of points that arrive out of order
+	}{
+		{"empty", 10, 0, 0},
+		{"size1", 1, 50, 0.3},
+		{"small", 3, 20, 0.5},
+		{"inOrder", 500, 2000, 0},
+		{"outOfOrder", 500, 2000, 0.1},
+		{"mostlyOutOfOrder", 250, 1000, 0.9},
+		{"notFull", 1000, 300, 0.2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			r := newRing(tt.size)
+			g := newGorilla(tt.size)
+			ts := start
+			for i := 0; i < tt.points; i++ {
+				ts = ts.Add(time.Second + time.Duration(rnd.Intn(1000)))
+				c := Count{N: float64(rnd.Intn(10)), T: ts}
+				if rnd.Float64() < tt.outOfOrder {
+					c.T = ts.Add(-time.Duration(rnd.Int63n(int64(time.Duration(tt.size) * time.Second))))
+				}
+				r.insert(c)
+				g.insert(c)
+			}
+			if g.len() != r.len() {
+				t.Errorf("gorilla.len(): got %d, want %d", g.len(), r.len())
+			}
+			got := g.appendRange([]Count{}, minTime, maxTime)
+			want := r.appendRange([]Count{}, minTime, maxTime)
+			if !cmp.Equal(got, want) {
+				t.Errorf("gorilla.insert():\ndiff:\n%s", cmp.Diff(got, want))
+			}
+
+			// Select a range in the middle of the data.
+			if len(want) > 4 {
+				from, to := want[len(want)/4].T, want[len(want)*3/4].T
+				got = g.appendRange(nil, from, to)
+				want = r.appendRange(nil, from, to)
+				if !cmp.Equal(got, want) {
+					t.Errorf("gorilla.appendRange(%v, %v):\ndiff:\n%s", from, to, cmp.Diff(got, want))
+				}
+			}
+		})
+	}
+}
+
+func TestGorilla_insertOutOfRange(t *testing.T) {
+	valid := []Count{
+		{N: 1, T: time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)},
+		{N: 2, T: time.Date(2017, time.October, 25, 11, 16, 55, 0, time.UTC)},
+	}
+	early := Count{N: 3, T: time.Time{}}
+	late := Count{N: 4, T: time.Date(2300, time.January, 1, 0, 0, 0, 0, time.UTC)}
+
+	g := newGorilla(10)
+	for _, c := range []Count{valid[0], early, valid[1], late} {
+		g.insert(c)
+	}
+	if got := g.appendRange(nil, minTime, maxTime); !cmp.Equal(got, valid) {
+		t.Errorf("gorilla.insert():\ndiff:\n%s", cmp.Diff(got, valid))
+	}
+
+	g = newGorilla(10)
+	g.insertSorted([]Count{early, valid[0], valid[1], late})
+	if got := g.appendRange(nil, minTime, maxTime); !cmp.Equal(got, valid) {
+		t.Errorf("gorilla.insertSorted():\ndiff:\n%s", cmp.Diff(got, valid))
+	}
+}
+
+// TestStore_insertSorted inserts sorted batches into both stores
+// and compares the result with Counts inserted one by one.
+func TestStore_insertSorted(t *testing.T) {
+	start := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		size    int
+		batches int
+		batch   int
+		spread  time.Duration // how far a batch reaches back in time
+	}{
+		{"inOrder", 300, 20, 50, 0},
+		{"overlapping", 300, 20, 50, 100 * time.Second},
+		{"olderThanAll", 100, 10, 30, 1000 * time.Second},
+		{"largerThanSize", 10, 5, 40, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			want := newRing(tt.size)
+			r := newRing(tt.size)
+			g := newGorilla(tt.size)
+			ts := start
+			for b := 0; b < tt.batches; b++ {
+				batch := []Count{}
+				for i := 0; i < tt.batch; i++ {
+					ts = ts.Add(time.Second)
+					c := Count{N: float64(rnd.Intn(10)), T: ts}
+					if tt.spread > 0 {
+						c.T = c.T.Add(-time.Duration(rnd.Int63n(int64(tt.spread))))
+					}
+					batch = append(batch, c)
+					want.insert(c)
+				}
+				sort.SliceStable(batch, func(i, j int) bool { return batch[i].T.Before(batch[j].T) })
+				r.insertSorted(batch)
+				g.insertSorted(batch)
+			}
+			w := want.appendRange([]Count{}, minTime, maxTime)
+			if got := r.appendRange([]Count{}, minTime, maxTime); !cmp.Equal(got, w) {
+				t.Errorf("ring.insertSorted():\ndiff:\n%s", cmp.Diff(got, w))
+			}
+			if got := g.appendRange([]Count{}, minTime, maxTime); !cmp.Equal(got, w) {
+				t.Errorf("gorilla.insertSorted():\ndiff:\n%s", cmp.Diff(got, w))
+			}
+			if r.len() != want.len() || g.len() != want.len() {
+				t.Errorf("insertSorted(): got len %d (ring), %d (gorilla), want %d", r.len(), g.len(), want.len())
+			}
+		})
+	}
+}
+
+// benchStart is the timestamp of the first data point of newBenchMetric().
+var benchStart = time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+
+// newBenchMetric returns a Metric with a full buffer of size data points,
+// one per second from benchStart on, with a slowly changing value.
+// Like the metrics of a Dashboard, the Metric has ingestion buffers.
+func newBenchMetric(data store, size int) *Metric {
+	g := newMetric(data)
+	for i := 0; i < size; i++ {
+		g.AddWithTime(float64(20+i%7), benchStart.Add(time.Duration(i)*time.Second))
+	}
+	g.Len() // flush the ingestion buffers
+	return g
+}
+
+var stores = []struct {
+	name string
+	new  func(size int) store
+}{
+	{"ring", func(size int) store { return newRing(size) }},
+	{"gorilla", func(size int) store { return newGorilla(size) }},
+}
+
+func BenchmarkMetric_memory(b *testing.B) {
+	const size = 100000
+	for _, s := range stores {
+		b.Run(s.name, func(b *testing.B) {
+			var m1, m2 runtime.MemStats
+			var g *Metric
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				runtime.ReadMemStats(&m1)
+				g = newBenchMetric(s.new(size), size)
+				runtime.GC()
+				runtime.ReadMemStats(&m2)
+			}
+			b.ReportMetric(float64(m2.HeapAlloc-m1.HeapAlloc)/size, "B/point")
+			runtime.KeepAlive(g)
+		})
+	}
+}
+
+// BenchmarkMetric_AddWithTime adds data points after the prefilled ones,
+// either all in order, or with every tenth data point going back in time
+// by half the buffer.
+func BenchmarkMetric_AddWithTime(b *testing.B) {
+	const size = 10000
+	for _, s := range stores {
+		for _, outOfOrder := range []bool{false, true} {
+			name := s.name + "/inOrder"
+			if outOfOrder {
+				name = s.name + "/outOfOrder"
+			}
+			b.Run(name, func(b *testing.B) {
+				g := newBenchMetric(s.new(size), size)
+				start := benchStart.Add(size * time.Second)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					t := start.Add(time.Duration(i) * time.Second)
+					if outOfOrder && i%10 == 0 {
+						t = t.Add(-size / 2 * time.Second)
+					}
+					g.AddWithTime(float64(i%7), t)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkMetric_fetchDatapoints(b *testing.B) {
+	start := benchStart
+	for _, size := range []int{1000, 100000} {
+		for _, s := range stores {
+			b.Run(s.name+"/"+strconv.Itoa(size), func(b *testing.B) {
+				g := newBenchMetric(s.new(size), size)
+				from := start.Add(time.Duration(size/2) * time.Second)
+				to := start.Add(time.Duration(size) * time.Second)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					g.fetchDatapoints(from, to, 500)
+				}
+			})
+		}
+	}
+}
@@ -8,7 +8,7 @@ package grada
 // Grafana sends three queries:
 // * /search for retrieving the available targets
 // * /query for requesting new sets of data
-// * /annotation for requesting chart annotations
+// * /annotations for requesting chart annotations
 
 import (
 	"bytes"
@@ -83,7 +83,9 @@ var debug bool
 // by target name. When Grafana requests new data for a target,
 // the server returns the current list of metrics for that target.
 type server struct {
-	metrics *metrics
+	metrics     *metrics
+	annotations annotations
+	http        *http.Server
 }
 
 func writeError(w http.ResponseWriter, e error, m string) {
@@ -181,11 +183,7 @@ func (srv *server) sendTable(w http.ResponseWriter, q *query) {
 // These names are shown in the metrics dropdown when selecting a metric in
 // the Metrics tab of a panel.
 func (srv *server) searchHandler(w http.ResponseWriter, r *http.Request) {
-	var targets []string
-	for t, _ := range srv.metrics.metric {
-		targets = append(targets, t)
-	}
-	resp, err := json.Marshal(targets)
+	resp, err := json.Marshal(srv.metrics.Targets())
 	if err != nil {
 		writeError(w, err, "cannot marshal targets response")
 	}
@@ -208,6 +206,7 @@ func startServer() *server {
 
 	http.HandleFunc("/query", server.queryHandler)
 	http.HandleFunc("/search", server.searchHandler)
+	http.HandleFunc("/annotations", server.annotationsHandler)
 
 	// Determine the port. Default is 3001 but can be changed via
 	// environment variable GRADA_PORT.
@@ -218,6 +217,7 @@ func startServer() *server {
 	}
 
 	// Start the server.
-	go http.ListenAndServe(":"+port, nil)
+	server.http = &http.Server{Addr: ":" + port}
+	go server.http.ListenAndServe()
 	return server
 }
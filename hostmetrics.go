@@ -0,0 +1,302 @@
+package grada
+
+// A collector for metrics of the host system: CPU, memory, load, network,
+// and disks. The collector reads the files of the Linux /proc file system.
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hostCollector samples /proc files and adds the values to grada metrics.
+type hostCollector struct {
+	d      *Dashboard
+	size   int
+	root   string
+	prefix string
+
+	// The previous values of counters, for calculating rates
+	prevT time.Time
+	prev  map[string]float64
+}
+
+// CollectHostMetrics starts collecting metrics of the host system every interval.
+// It reads the files stat, meminfo, loadavg, net/dev, and diskstats from
+// the directory procRoot, which defaults to "/proc" if empty, and therefore
+// only works on Linux.
+//
+// CollectHostMetrics creates the following metrics, with each target name
+// starting with prefix:
+//
+//	cpu.usage                       percentage of CPU time not spent idle or waiting for I/O
+//	cpu.user, cpu.system            percentage of CPU time spent in user and kernel mode
+//	cpu.iowait                      percentage of CPU time spent waiting for I/O
+//	mem.total, mem.available        total and available memory in bytes
+//	mem.used                        memory in use (total minus available) in bytes
+//	mem.used.percent                memory in use in percent of the total memory
+//	swap.used                       swap space in use in bytes
+//	load.1, load.5, load.15         the load averages over 1, 5, and 15 minutes
+//	net.<iface>.rx.bytes            bytes received per second, per network interface
+//	net.<iface>.tx.bytes            bytes sent per second
+//	net.<iface>.rx.packets          packets received per second
+//	net.<iface>.tx.packets          packets sent per second
+//	disk.<device>.read.bytes        bytes read per second, per disk device
+//	disk.<device>.write.bytes       bytes written per second
+//	disk.<device>.reads             read operations per second
+//	disk.<device>.writes            write operations per second
+//	disk.<device>.busy              percentage of time with I/O operations in progress
+//
+// Percentages and rates refer to the period since the previous sample; therefore,
+// they get recorded from the second sample on.
+// timeRange and interval determine the buffer size of the metrics,
+// see CreateMetric(). Existing metrics of the same name are reused.
+//
+// If procRoot/stat cannot be read, CollectHostMetrics returns an error.
+// Other files that cannot be read or parsed are skipped silently.
+//
+// Collecting stops when the returned function is called,
+// or when the dashboard shuts down.
+func (d *Dashboard) CollectHostMetrics(procRoot, prefix string, timeRange, interval time.Duration) (stop func(), err error) {
+	if procRoot == "" {
+		procRoot = "/proc"
+	}
+	if _, err := os.Stat(filepath.Join(procRoot, "stat")); err != nil {
+		return nil, errors.New("cannot collect host metrics: " + err.Error())
+	}
+	c := &hostCollector{
+		d:      d,
+		size:   bufSizeFor(timeRange, interval),
+		root:   procRoot,
+		prefix: prefix,
+		prev:   map[string]float64{},
+	}
+	c.collect(time.Now())
+	return d.every(interval, func(t time.Time) bool {
+		c.collect(t)
+		return true
+	}), nil
+}
+
+// collect reads all /proc files and adds their values to the grada metrics
+// with timestamp now.
+func (c *hostCollector) collect(now time.Time) {
+	for _, f := range []struct {
+		name  string
+		parse func([]byte, time.Time)
+	}{
+		{"stat", c.parseStat},
+		{"meminfo", c.parseMeminfo},
+		{"loadavg", c.parseLoadavg},
+		{"net/dev", c.parseNetDev},
+		{"diskstats", c.parseDiskstats},
+	} {
+		data, err := os.ReadFile(filepath.Join(c.root, f.name))
+		if err != nil {
+			continue
+		}
+		f.parse(data, now)
+	}
+	c.prevT = now
+}
+
+// gauge adds v to the target.
+func (c *hostCollector) gauge(target string, v float64, now time.Time) {
+	c.d.srv.metrics.GetOrCreate(c.prefix+target, c.size).AddWithTime(v, now)
+}
+
+// rate adds the change per second of the counter v to the target.
+// For the first sample of a counter, or after the counter has been reset,
+// rate only records the counter value.
+func (c *hostCollector) rate(target string, v float64, now time.Time) {
+	prev, ok := c.prev[target]
+	c.prev[target] = v
+	if !ok || v < prev || c.prevT.IsZero() {
+		return
+	}
+	c.gauge(target, (v-prev)/now.Sub(c.prevT).Seconds(), now)
+}
+
+// delta returns the change of the counter v since the previous sample,
+// or false for the first sample or after the counter has been reset.
+func (c *hostCollector) delta(key string, v float64) (float64, bool) {
+	prev, ok := c.prev[key]
+	c.prev[key] = v
+	if !ok || v < prev {
+		return 0, false
+	}
+	return v - prev, true
+}
+
+// parseFloats parses all fields as numbers. Fields that are not numbers become 0.
+func parseFloats(fields []string) []float64 {
+	values := make([]float64, len(fields))
+	for i, f := range fields {
+		values[i], _ = strconv.ParseFloat(f, 64)
+	}
+	return values
+}
+
+// parseStat parses the aggregated "cpu" line of /proc/stat:
+//
+//	cpu  user nice system idle iowait irq softirq steal guest guest_nice
+func (c *hostCollector) parseStat(data []byte, now time.Time) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		v := parseFloats(fields[1:])
+		// guest and guest_nice are already included in user and nice.
+		if len(v) > 8 {
+			v = v[:8]
+		}
+		var total float64
+		for _, n := range v {
+			total += n
+		}
+		user := v[0] + v[1]
+		system := v[2]
+		idle := v[3]
+		var iowait float64
+		if len(v) > 4 {
+			iowait = v[4]
+		}
+		if len(v) > 6 {
+			system += v[5] + v[6] // irq and softirq
+		}
+
+		dTotal, ok1 := c.delta("cpu.total", total)
+		dUser, ok2 := c.delta("cpu.user", user)
+		dSystem, ok3 := c.delta("cpu.system", system)
+		dIdle, ok4 := c.delta("cpu.idle", idle)
+		dIowait, ok5 := c.delta("cpu.iowait", iowait)
+		if !(ok1 && ok2 && ok3 && ok4 && ok5) || dTotal == 0 {
+			return
+		}
+		c.gauge("cpu.usage", 100*(dTotal-dIdle-dIowait)/dTotal, now)
+		c.gauge("cpu.user", 100*dUser/dTotal, now)
+		c.gauge("cpu.system", 100*dSystem/dTotal, now)
+		c.gauge("cpu.iowait", 100*dIowait/dTotal, now)
+		return
+	}
+}
+
+// parseMeminfo parses /proc/meminfo, which consists of lines like
+//
+//	MemTotal:       16303428 kB
+func (c *hostCollector) parseMeminfo(data []byte, now time.Time) {
+	mem := map[string]float64{}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			v *= 1024
+		}
+		mem[strings.TrimSuffix(fields[0], ":")] = v
+	}
+
+	total, ok := mem["MemTotal"]
+	if !ok {
+		return
+	}
+	available, ok := mem["MemAvailable"]
+	if !ok {
+		// Kernels before 3.14 do not report MemAvailable.
+		available = mem["MemFree"] + mem["Buffers"] + mem["Cached"]
+	}
+	c.gauge("mem.total", total, now)
+	c.gauge("mem.available", available, now)
+	c.gauge("mem.used", total-available, now)
+	if total > 0 {
+		c.gauge("mem.used.percent", 100*(total-available)/total, now)
+	}
+	if swapTotal, ok := mem["SwapTotal"]; ok {
+		c.gauge("swap.used", swapTotal-mem["SwapFree"], now)
+	}
+}
+
+// parseLoadavg parses /proc/loadavg:
+//
+//	0.20 0.18 0.12 1/80 11206
+func (c *hostCollector) parseLoadavg(data []byte, now time.Time) {
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return
+	}
+	for i, target := range []string{"load.1", "load.5", "load.15"} {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return
+		}
+		c.gauge(target, v, now)
+	}
+}
+
+// parseNetDev parses /proc/net/dev. After two header lines, each line
+// contains an interface name and its receive and transmit counters:
+//
+//	eth0: bytes packets errs drop fifo frame compressed multicast bytes packets errs drop fifo colls carrier compressed
+func (c *hostCollector) parseNetDev(data []byte, now time.Time) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		iface, counters, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		iface = strings.TrimSpace(iface)
+		v := parseFloats(strings.Fields(counters))
+		if len(v) < 10 {
+			continue
+		}
+		c.rate("net."+iface+".rx.bytes", v[0], now)
+		c.rate("net."+iface+".rx.packets", v[1], now)
+		c.rate("net."+iface+".tx.bytes", v[8], now)
+		c.rate("net."+iface+".tx.packets", v[9], now)
+	}
+}
+
+// sectorSize is the size of a sector in /proc/diskstats, regardless of the device.
+const sectorSize = 512
+
+// parseDiskstats parses /proc/diskstats. Each line contains the counters of a device:
+//
+//	major minor name reads merged sectors ms writes merged sectors ms in_progress ms_io ms_weighted ...
+//
+// Loop and RAM devices are skipped.
+func (c *hostCollector) parseDiskstats(data []byte, now time.Time) {
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 14 {
+			continue
+		}
+		dev := fields[2]
+		if strings.HasPrefix(dev, "loop") || strings.HasPrefix(dev, "ram") {
+			continue
+		}
+		v := parseFloats(fields[3:])
+		c.rate("disk."+dev+".reads", v[0], now)
+		c.rate("disk."+dev+".read.bytes", v[2]*sectorSize, now)
+		c.rate("disk."+dev+".writes", v[4], now)
+		c.rate("disk."+dev+".write.bytes", v[6]*sectorSize, now)
+		// ms_io is the number of milliseconds spent doing I/O.
+		d, ok := c.delta("disk."+dev+".ms_io", v[9])
+		if ms := now.Sub(c.prevT).Milliseconds(); ok && !c.prevT.IsZero() && ms > 0 {
+			c.gauge("disk."+dev+".busy", 100*d/float64(ms), now)
+		}
+	}
+}
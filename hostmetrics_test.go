@@ -0,0 +1,89 @@
+package grada
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHostCollector_collect(t *testing.T) {
+	d := newTestDashboard()
+	c := &hostCollector{
+		d:      d,
+		size:   10,
+		root:   "testdata/proc/t0",
+		prefix: "host.",
+		prev:   map[string]float64{},
+	}
+	t0 := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+	c.collect(t0)
+	c.root = "testdata/proc/t1"
+	c.collect(t0.Add(time.Second))
+
+	tests := []struct {
+		target string
+		want   float64
+		points int
+	}{
+		{"host.cpu.usage", 50, 1},
+		{"host.cpu.user", 40, 1},
+		{"host.cpu.system", 10, 1},
+		{"host.cpu.iowait", 10, 1},
+		{"host.mem.total", 8000000 * 1024, 2},
+		{"host.mem.available", 2000000 * 1024, 2},
+		{"host.mem.used", 6000000 * 1024, 2},
+		{"host.mem.used.percent", 75, 2},
+		{"host.swap.used", 1000000 * 1024, 2},
+		{"host.load.1", 1.5, 2},
+		{"host.load.5", 0.75, 2},
+		{"host.load.15", 0.3, 2},
+		{"host.net.eth0.rx.bytes", 200000, 1},
+		{"host.net.eth0.tx.bytes", 40000, 1},
+		{"host.net.eth0.rx.packets", 200, 1},
+		{"host.net.eth0.tx.packets", 40, 1},
+		{"host.net.lo.rx.bytes", 0, 1},
+		{"host.disk.sda.reads", 100, 1},
+		{"host.disk.sda.read.bytes", 2000 * 512, 1},
+		{"host.disk.sda.writes", 400, 1},
+		{"host.disk.sda.write.bytes", 8000 * 512, 1},
+		{"host.disk.sda.busy", 50, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			m, err := d.Metric(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Len(); got != tt.points {
+				t.Errorf("got %d data points, want %d", got, tt.points)
+			}
+			if got, _ := m.Last(); got.N != tt.want {
+				t.Errorf("got %f, want %f", got.N, tt.want)
+			}
+		})
+	}
+
+	if _, err := d.Metric("host.disk.loop0.reads"); err == nil {
+		t.Errorf("loop device not skipped")
+	}
+}
+
+func TestDashboard_CollectHostMetrics(t *testing.T) {
+	d := newTestDashboard()
+	if _, err := d.CollectHostMetrics("testdata/proc/nonexistent", "host.", time.Minute, time.Second); err == nil {
+		t.Errorf("CollectHostMetrics() with invalid procRoot: want error")
+	}
+
+	stop, err := d.CollectHostMetrics("testdata/proc/t0", "host.", time.Minute, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CollectHostMetrics(): %v", err)
+	}
+	time.Sleep(35 * time.Millisecond)
+	stop()
+	m, err := d.Metric("host.load.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() < 2 {
+		t.Errorf("CollectHostMetrics(): got %d data points, want 2 or more", m.Len())
+	}
+}
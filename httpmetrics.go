@@ -0,0 +1,205 @@
+package grada
+
+// Middleware that records metrics of HTTP requests.
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// httpMetrics collects the statistics of HTTP requests per route
+// and adds them to grada metrics once per interval.
+type httpMetrics struct {
+	d        *Dashboard
+	size     int
+	prefix   string
+	interval time.Duration
+
+	mu     sync.Mutex
+	routes map[string]*routeStats
+}
+
+// routeStats are the statistics of a route since the previous interval.
+type routeStats struct {
+	requests  float64
+	errors    float64
+	inflight  int
+	latencies []float64 // in seconds
+}
+
+// statusWriter records the status code of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, if the original ResponseWriter does,
+// so that wrapped handlers can stream responses.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, if the original ResponseWriter does,
+// so that wrapped handlers can take over the connection, as for WebSockets.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the ResponseWriter does not support hijacking")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// ReadFrom implements io.ReaderFrom, so that the original ResponseWriter
+// can still send files efficiently.
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// Unwrap lets http.ResponseController reach the original ResponseWriter.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// HTTPMetrics returns a middleware that records metrics of the requests
+// to an http.Handler. Wrap each handler with a route name:
+//
+//	mw, stop := d.HTTPMetrics("http.", time.Hour, 10*time.Second)
+//	defer stop()
+//	http.Handle("/api/users/", mw("users", usersHandler))
+//	http.Handle("/api/orders/", mw("orders", ordersHandler))
+//
+// For each route, the middleware creates the following metrics, with each
+// target name starting with prefix and the route:
+//
+//	users.requests        requests per second
+//	users.errors          responses with a status code of 500 or above per second
+//	users.inflight        requests in progress at the end of the interval
+//	users.latency.p50     median latency in seconds
+//	users.latency.p90     90th percentile of the latency
+//	users.latency.p99     99th percentile of the latency
+//	users.latency.max     highest latency
+//
+// The metrics get recorded every interval; the latencies only if there
+// were requests in that interval. A handler that panics counts as an error.
+//
+// timeRange and interval determine the buffer size of the metrics,
+// see CreateMetric(). Existing metrics of the same name are reused;
+// therefore, middlewares with the same prefix write into the same targets.
+// Create one middleware and wrap all handlers with it, or give each
+// middleware a prefix of its own.
+//
+// Recording stops when the returned function is called,
+// or when the dashboard shuts down.
+func (d *Dashboard) HTTPMetrics(prefix string, timeRange, interval time.Duration) (mw func(route string, next http.Handler) http.Handler, stop func()) {
+	hm := newHTTPMetrics(d, prefix, bufSizeFor(timeRange, interval), interval)
+	stop = d.every(interval, func(t time.Time) bool {
+		hm.flush(t)
+		return true
+	})
+	return hm.wrap, stop
+}
+
+// newHTTPMetrics creates an httpMetrics without any routes.
+func newHTTPMetrics(d *Dashboard, prefix string, size int, interval time.Duration) *httpMetrics {
+	return &httpMetrics{
+		d:        d,
+		size:     size,
+		prefix:   prefix,
+		interval: interval,
+		routes:   map[string]*routeStats{},
+	}
+}
+
+// wrap returns a handler that records the requests to next.
+func (hm *httpMetrics) wrap(route string, next http.Handler) http.Handler {
+	hm.mu.Lock()
+	if hm.routes[route] == nil {
+		hm.routes[route] = &routeStats{}
+	}
+	rs := hm.routes[route]
+	hm.mu.Unlock()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hm.mu.Lock()
+		rs.inflight++
+		hm.mu.Unlock()
+
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		defer func() {
+			failed := recover()
+			latency := time.Since(start).Seconds()
+			hm.mu.Lock()
+			rs.inflight--
+			rs.requests++
+			if failed != nil || sw.status >= 500 {
+				rs.errors++
+			}
+			rs.latencies = append(rs.latencies, latency)
+			hm.mu.Unlock()
+			if failed != nil {
+				panic(failed)
+			}
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// flush adds the statistics of the past interval to the metrics
+// with timestamp now, and resets them.
+func (hm *httpMetrics) flush(now time.Time) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	for route, rs := range hm.routes {
+		target := hm.prefix + route
+		hm.add(target+".requests", rs.requests/hm.interval.Seconds(), now)
+		hm.add(target+".errors", rs.errors/hm.interval.Seconds(), now)
+		hm.add(target+".inflight", float64(rs.inflight), now)
+		if len(rs.latencies) > 0 {
+			slices.Sort(rs.latencies)
+			for _, q := range quantiles {
+				hm.add(target+".latency"+q.suffix, percentile(rs.latencies, q.q), now)
+			}
+		}
+		rs.requests, rs.errors, rs.latencies = 0, 0, rs.latencies[:0]
+	}
+}
+
+// add adds v to the target.
+func (hm *httpMetrics) add(target string, v float64, now time.Time) {
+	hm.d.srv.metrics.GetOrCreate(target, hm.size).AddWithTime(v, now)
+}
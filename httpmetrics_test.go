@@ -0,0 +1,141 @@
+package grada
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPMetrics(t *testing.T) {
+	d := newTestDashboard()
+	hm := newHTTPMetrics(d, "http.", 10, time.Hour)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	h := hm.wrap("api", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fail":
+			http.Error(w, "failed", http.StatusInternalServerError)
+		case "/missing":
+			http.NotFound(w, r)
+		case "/panic":
+			panic("oops")
+		case "/slow":
+			close(started)
+			<-release
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+
+	for _, path := range []string{"/", "/", "/fail", "/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("HTTPMetrics(): panic did not propagate")
+			}
+		}()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil))
+	}()
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/slow", nil))
+		close(done)
+	}()
+	<-started
+
+	hm.flush(time.Now())
+	close(release)
+	<-done
+
+	tests := []struct {
+		target string
+		want   float64
+	}{
+		{"http.api.requests", 5.0 / 3600},
+		{"http.api.errors", 2.0 / 3600},
+		{"http.api.inflight", 1},
+		{"http.api.latency.max", -1}, // any value
+	}
+	for _, tt := range tests {
+		m, err := d.Metric(tt.target)
+		if err != nil {
+			t.Errorf("%s: %v", tt.target, err)
+			continue
+		}
+		if c, _ := m.Last(); tt.want >= 0 && c.N != tt.want {
+			t.Errorf("%s: got %f, want %f", tt.target, c.N, tt.want)
+		}
+	}
+}
+
+func TestStatusWriter_interfaces(t *testing.T) {
+	d := newTestDashboard()
+	hm := newHTTPMetrics(d, "http.", 10, time.Hour)
+
+	flushed := hm.wrap("events", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		if !ok {
+			t.Fatal("wrapped ResponseWriter is no http.Flusher")
+		}
+		w.Write([]byte("data: 1\n\n"))
+		f.Flush()
+	}))
+	rec := httptest.NewRecorder()
+	flushed.ServeHTTP(rec, httptest.NewRequest("GET", "/events", nil))
+	if !rec.Flushed {
+		t.Error("Flush() did not reach the original ResponseWriter")
+	}
+
+	hijacked := hm.wrap("ws", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("wrapped ResponseWriter is no http.Hijacker")
+			return
+		}
+		conn, buf, err := h.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: close\r\n\r\n")
+		buf.Flush()
+	}))
+	ts := httptest.NewServer(hijacked)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("hijacked response: got status %d, want 101", resp.StatusCode)
+	}
+
+	// Hijack fails cleanly if the original ResponseWriter cannot hijack.
+	sw := &statusWriter{ResponseWriter: httptest.NewRecorder()}
+	if _, _, err := sw.Hijack(); err == nil {
+		t.Error("Hijack() on a ResponseRecorder: want error")
+	}
+}
+
+func TestDashboard_HTTPMetricsStop(t *testing.T) {
+	d := newTestDashboard()
+	mw, stop := d.HTTPMetrics("http.", time.Minute, 5*time.Millisecond)
+	mw("api", http.NotFoundHandler())
+	time.Sleep(20 * time.Millisecond)
+	stop()
+
+	m, err := d.Metric("http.api.requests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := m.Len()
+	time.Sleep(20 * time.Millisecond)
+	if m.Len() != n {
+		t.Errorf("HTTPMetrics(): still recording after stop")
+	}
+}
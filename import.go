@@ -0,0 +1,141 @@
+package grada
+
+// Bulk import of historical data points, for example from an earlier export.
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+)
+
+// ImportCSV loads data points from CSV data, such as an export from
+// ExportHandler(). The first line is a header that names the columns
+// "target", "timestamp", and "value", in any order; other columns are
+// ignored. Timestamps are either milliseconds since the Unix epoch or
+// in RFC 3339 format.
+//
+// See ImportJSON() for how the data points get added.
+func (d *Dashboard) ImportCSV(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return errors.New("cannot read CSV header: " + err.Error())
+	}
+	col := map[string]int{}
+	for i, name := range header {
+		col[name] = i
+	}
+	for _, name := range []string{"target", "timestamp", "value"} {
+		if _, ok := col[name]; !ok {
+			return errors.New("CSV header has no column " + name)
+		}
+	}
+
+	batch := map[string][]Count{}
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(rec) < len(header) {
+			return errors.New("line " + strconv.Itoa(line) + ": missing columns")
+		}
+		t, err := parseTime(rec[col["timestamp"]])
+		if err != nil {
+			return errors.New("line " + strconv.Itoa(line) + ": " + err.Error())
+		}
+		v, err := strconv.ParseFloat(rec[col["value"]], 64)
+		if err != nil {
+			return errors.New("line " + strconv.Itoa(line) + ": invalid value " + rec[col["value"]])
+		}
+		target := rec[col["target"]]
+		batch[target] = append(batch[target], Count{N: v, T: t})
+	}
+	return d.importBatch(batch)
+}
+
+// ImportJSON loads data points from JSON data, either an array of objects
+// as WriteHandler() accepts them, or JSON Lines as ExportHandler() returns them:
+//
+//	{"target": "queue.length", "value": 42, "timestamp": "2017-10-25T11:16:54Z"}
+//
+// Timestamps are either milliseconds since the Unix epoch or
+// in RFC 3339 format.
+//
+// Missing metrics are created with a buffer that holds twice the number
+// of imported data points, leaving room for as many new data points.
+// Call Metric.Resize() or Metric.ResizeFor() to change the buffer size.
+// If a metric exists already, the imported data points get added to it,
+// and if its buffer is too small, the oldest data points get dropped.
+//
+// The data points are added through Dashboard.AddBatch(), so that Grafana
+// sees either none or all of them. If the data cannot be parsed,
+// ImportJSON returns an error and adds no data points at all.
+func (d *Dashboard) ImportJSON(r io.Reader) error {
+	br := bufio.NewReader(r)
+	dec := json.NewDecoder(br)
+	var points []point
+	if first, err := peekNonSpace(br); err == nil && first == '[' {
+		if err := dec.Decode(&points); err != nil {
+			return err
+		}
+	} else {
+		for {
+			var p point
+			err := dec.Decode(&p)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			points = append(points, p)
+		}
+	}
+
+	batch := map[string][]Count{}
+	for i, p := range points {
+		if p.Target == "" || p.Value == nil || len(p.Timestamp) == 0 || string(p.Timestamp) == "null" {
+			return errors.New("point " + strconv.Itoa(i) + ": missing target, value, or timestamp")
+		}
+		t, err := parseJSONTimestamp(p.Timestamp, time.Time{})
+		if err != nil {
+			return errors.New("point " + strconv.Itoa(i) + ": " + err.Error())
+		}
+		batch[p.Target] = append(batch[p.Target], Count{N: *p.Value, T: t})
+	}
+	return d.importBatch(batch)
+}
+
+// peekNonSpace returns the first byte of r that is not white space,
+// without consuming it.
+func peekNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.ReadByte()
+		default:
+			return b[0], nil
+		}
+	}
+}
+
+// importBatch creates missing metrics and adds the batch.
+func (d *Dashboard) importBatch(batch map[string][]Count) error {
+	for target, counts := range batch {
+		d.srv.metrics.GetOrCreate(target, 2*len(counts))
+	}
+	return d.AddBatch(batch)
+}
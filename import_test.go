@@ -0,0 +1,134 @@
+package grada
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestDashboard_ImportCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    map[string][]Count
+		wantErr bool
+	}{
+		{
+			"export",
+			"target,timestamp,value\n" +
+				"a,2017-10-25T11:16:55.123456789Z,1\n" +
+				"a,2017-10-25T11:16:54.123456789Z,0\n" +
+				"\"b,c\",1508930214000,NaN\n",
+			map[string][]Count{
+				"a": {
+					{N: 0, T: time.Date(2017, 10, 25, 11, 16, 54, 123456789, time.UTC)},
+					{N: 1, T: time.Date(2017, 10, 25, 11, 16, 55, 123456789, time.UTC)},
+				},
+				"b,c": {{N: math.NaN(), T: time.Unix(1508930214, 0)}},
+			},
+			false,
+		},
+		{
+			"columnOrder",
+			"value,comment,target,timestamp\n2.5,x,a,1508930214000\n",
+			map[string][]Count{"a": {{N: 2.5, T: time.Unix(1508930214, 0)}}},
+			false,
+		},
+		{"empty", "", nil, true},
+		{"missingColumn", "target,value\na,1\n", nil, true},
+		{"invalidValue", "target,timestamp,value\na,1508930214000,one\n", nil, true},
+		{"invalidTimestamp", "target,timestamp,value\na,yesterday,1\n", nil, true},
+		{"shortLine", "target,timestamp,value\na,1508930214000\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDashboard()
+			err := d.ImportCSV(strings.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImportCSV(): error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if len(d.Metrics()) > 0 {
+					t.Errorf("ImportCSV(): failed import created metrics")
+				}
+				return
+			}
+			checkImport(t, d, tt.want)
+		})
+	}
+}
+
+func TestDashboard_ImportJSON(t *testing.T) {
+	want := map[string][]Count{
+		"a": {{N: 0, T: time.Unix(1508930214, 0)}, {N: 1, T: time.Unix(1508930215, 0)}},
+		"b": {{N: 2, T: time.Date(2017, 10, 25, 11, 16, 54, 5, time.UTC)}},
+	}
+	tests := []struct {
+		name    string
+		data    string
+		wantErr bool
+	}{
+		{
+			"array",
+			` [{"target": "a", "value": 1, "timestamp": 1508930215000},
+			  {"target": "b", "value": 2, "timestamp": "2017-10-25T11:16:54.000000005Z"},
+			  {"target": "a", "value": 0, "timestamp": 1508930214000}]`,
+			false,
+		},
+		{
+			"jsonLines",
+			`{"target":"a","value":0,"timestamp":"2017-10-25T11:16:54Z"}
+{"target":"a","value":1,"timestamp":"2017-10-25T11:16:55Z"}
+{"target":"b","value":2,"timestamp":"2017-10-25T11:16:54.000000005Z"}
+`,
+			false,
+		},
+		{"missingTimestamp", `[{"target": "a", "value": 1}]`, true},
+		{"nullTimestamp", `[{"target": "a", "value": 1, "timestamp": null}]`, true},
+		{"invalidLine", `{"target":"a","value":0,"timestamp":1}` + "\n{", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDashboard()
+			err := d.ImportJSON(strings.NewReader(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImportJSON(): error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if len(d.Metrics()) > 0 {
+					t.Errorf("ImportJSON(): failed import created metrics")
+				}
+				return
+			}
+			checkImport(t, d, want)
+		})
+	}
+}
+
+// checkImport compares the metrics of d and their Counts with want.
+func checkImport(t *testing.T, d *Dashboard, want map[string][]Count) {
+	t.Helper()
+	for _, info := range d.Metrics() {
+		if _, ok := want[info.Target]; !ok {
+			t.Errorf("unexpected metric %s", info.Target)
+		}
+	}
+	for target, w := range want {
+		m, err := d.Metric(target)
+		if err != nil {
+			t.Errorf("%s: %v", target, err)
+			continue
+		}
+		if m.info(target).Size != 2*len(w) {
+			t.Errorf("%s: got size %d, want %d", target, m.info(target).Size, 2*len(w))
+		}
+		got := m.Range(exportFrom, exportTo)
+		if diff := cmp.Diff(got, w, cmpopts.EquateNaNs()); diff != "" {
+			t.Errorf("%s: diff:\n%s", target, diff)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package grada
+
+// A log/slog handler that turns log records into metrics and annotations.
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogHandlerOptions are options for LogHandler(). The zero value
+// selects the defaults.
+type LogHandlerOptions struct {
+	// Prefix starts the target names of the metrics. The default is "log.".
+	Prefix string
+
+	// Level is the minimum level of the records that get counted.
+	// The default is slog.LevelInfo.
+	Level slog.Leveler
+
+	// AnnotationLevel is the minimum level of the records that become
+	// annotations. The default is slog.LevelError. To turn off annotations,
+	// set AnnotationLevel to a level above all levels in use, such as
+	// slog.Level(math.MaxInt).
+	AnnotationLevel slog.Leveler
+
+	// If ByMessage is true, the records get counted per message as well.
+	// Only use this option if the messages are constant strings,
+	// as each distinct message creates a new metric.
+	ByMessage bool
+}
+
+// logCounter counts log records and adds the rates to metrics.
+// All handlers derived from a LogHandler share a logCounter.
+type logCounter struct {
+	d        *Dashboard
+	size     int
+	prefix   string
+	interval time.Duration
+
+	mu     sync.Mutex
+	counts map[string]float64 // by target name, without prefix
+}
+
+// logHandler is the slog.Handler that LogHandler returns.
+type logHandler struct {
+	c               *logCounter
+	next            slog.Handler
+	level           slog.Leveler
+	annotationLevel slog.Leveler
+	byMessage       bool
+	group           string // the group prefix for the keys of attributes, like "g1.g2."
+	attrs           string // the preformatted attributes from WithAttrs()
+}
+
+// LogHandler returns a log/slog handler that counts log records per level
+// and turns records at or above a level into annotations (see Annotate()),
+// so that bursts of errors show up on the same Grafana panels as the metrics.
+// If next is not nil, the handler passes all records on to next,
+// so that the records still get logged:
+//
+//	h, stop := d.LogHandler(slog.NewTextHandler(os.Stderr, nil), time.Hour, 10*time.Second, nil)
+//	defer stop()
+//	logger := slog.New(h)
+//
+// The handler records the number of log records per second for each level
+// in a target named after the level, with the prefix from opts; for example,
+// "log.info", "log.warn", and "log.error". With opts.ByMessage, it also
+// records the rate per message in targets like `log.error{msg="cannot connect"}`.
+// The rates get recorded every interval, and are 0 for intervals without
+// log records.
+//
+// Annotations have the message as their title, the attributes of the record
+// in the form key=value as their text, and the tags "log" and the level,
+// such as "error".
+//
+// timeRange and interval determine the buffer size of the metrics,
+// see CreateMetric(). Existing metrics of the same name are reused;
+// therefore, handlers with the same prefix write into the same targets.
+// Give each handler a prefix of its own.
+//
+// Recording stops when the returned function is called,
+// or when the dashboard shuts down.
+func (d *Dashboard) LogHandler(next slog.Handler, timeRange, interval time.Duration, opts *LogHandlerOptions) (h slog.Handler, stop func()) {
+	if opts == nil {
+		opts = &LogHandlerOptions{}
+	}
+	lh := &logHandler{
+		c: &logCounter{
+			d:        d,
+			size:     bufSizeFor(timeRange, interval),
+			prefix:   opts.Prefix,
+			interval: interval,
+			counts:   map[string]float64{},
+		},
+		next:            next,
+		level:           opts.Level,
+		annotationLevel: opts.AnnotationLevel,
+		byMessage:       opts.ByMessage,
+	}
+	if lh.c.prefix == "" {
+		lh.c.prefix = "log."
+	}
+	if lh.level == nil {
+		lh.level = slog.LevelInfo
+	}
+	if lh.annotationLevel == nil {
+		lh.annotationLevel = slog.LevelError
+	}
+	// Record the standard levels from the start.
+	for _, l := range []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError} {
+		if l >= lh.level.Level() {
+			lh.c.counts[levelName(l)] = 0
+		}
+	}
+	stop = d.every(interval, func(t time.Time) bool {
+		lh.c.flush(t)
+		return true
+	})
+	return lh, stop
+}
+
+// levelName returns the name of the level in lower case, like "info" or "error+2".
+func levelName(l slog.Level) string {
+	return strings.ToLower(l.String())
+}
+
+// Enabled reports whether the handler counts or passes on records of the level.
+func (h *logHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= h.level.Level() || l >= h.annotationLevel.Level() ||
+		(h.next != nil && h.next.Enabled(ctx, l))
+}
+
+// Handle counts the record, creates an annotation for it if its level
+// is high enough, and passes it on to the next handler.
+func (h *logHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= h.level.Level() {
+		name := levelName(r.Level)
+		h.c.count(name)
+		if h.byMessage {
+			h.c.count(name + formatLabels([]label{{"msg", r.Message}}))
+		}
+	}
+	if r.Level >= h.annotationLevel.Level() {
+		t := r.Time
+		if t.IsZero() {
+			t = time.Now()
+		}
+		h.c.d.Annotate(Annotation{
+			Time:  t,
+			Title: r.Message,
+			Text:  h.text(r),
+			Tags:  []string{"log", levelName(r.Level)},
+		})
+	}
+	if h.next != nil && h.next.Enabled(ctx, r.Level) {
+		return h.next.Handle(ctx, r)
+	}
+	return nil
+}
+
+// text formats the attributes of the handler and of r as key=value pairs.
+func (h *logHandler) text(r slog.Record) string {
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.group, a)
+		return true
+	})
+	return strings.TrimPrefix(b.String(), " ")
+}
+
+// appendAttr appends " key=value" to b, with group as the prefix of the key.
+// Attributes of groups are flattened into keys like "group.key".
+func appendAttr(b *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, group, ga)
+		}
+		return
+	}
+	b.WriteString(" " + group + a.Key + "=" + a.Value.String())
+}
+
+// WithAttrs returns a handler that adds attrs to the text of annotations.
+func (h *logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	var b strings.Builder
+	b.WriteString(h.attrs)
+	for _, a := range attrs {
+		appendAttr(&b, h.group, a)
+	}
+	h2.attrs = b.String()
+	if h.next != nil {
+		h2.next = h.next.WithAttrs(attrs)
+	}
+	return &h2
+}
+
+// WithGroup returns a handler that qualifies the keys of subsequent
+// attributes with name.
+func (h *logHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group = h.group + name + "."
+	if h.next != nil {
+		h2.next = h.next.WithGroup(name)
+	}
+	return &h2
+}
+
+// count increments the counter for target.
+func (c *logCounter) count(target string) {
+	c.mu.Lock()
+	c.counts[target]++
+	c.mu.Unlock()
+}
+
+// flush adds the rates of the past interval to the metrics
+// and resets the counters.
+func (c *logCounter) flush(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for target, n := range c.counts {
+		c.d.srv.metrics.GetOrCreate(c.prefix+target, c.size).AddWithTime(n/c.interval.Seconds(), now)
+		c.counts[target] = 0
+	}
+}
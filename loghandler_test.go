@@ -0,0 +1,90 @@
+package grada
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDashboard_LogHandler(t *testing.T) {
+	d := newTestDashboard()
+	var out bytes.Buffer
+	next := slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelWarn})
+	// A long interval, so that only the flushes below record data.
+	h, stop := d.LogHandler(next, time.Hour, time.Hour, &LogHandlerOptions{
+		Prefix:          "app.log.",
+		AnnotationLevel: slog.LevelWarn,
+		ByMessage:       true,
+	})
+	defer stop()
+	logger := slog.New(h)
+
+	if h.Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("Enabled(LevelDebug): got true, want false")
+	}
+	logger.Debug("ignored")
+	logger.Info("started")
+	logger.Info("started")
+	logger.With("db", "users").WithGroup("req").Warn("slow query", "ms", 820, slog.Group("user", "id", 7))
+	logger.Error("cannot connect", "err", "timeout")
+	h.(*logHandler).c.flush(time.Now())
+
+	tests := []struct {
+		target string
+		want   float64
+	}{
+		{"app.log.info", 2},
+		{"app.log.warn", 1},
+		{"app.log.error", 1},
+		{`app.log.info{msg="started"}`, 2},
+		{`app.log.error{msg="cannot connect"}`, 1},
+	}
+	for _, tt := range tests {
+		m, err := d.Metric(tt.target)
+		if err != nil {
+			t.Errorf("%s: %v", tt.target, err)
+			continue
+		}
+		if c, _ := m.Last(); c.N != tt.want/3600 {
+			t.Errorf("%s: got %f records per second, want %f", tt.target, c.N, tt.want/3600)
+		}
+	}
+	if _, err := d.Metric("app.log.debug"); err == nil {
+		t.Error("LogHandler(): debug records got counted")
+	}
+
+	as := d.Annotations(minTime, maxTime)
+	if len(as) != 2 {
+		t.Fatalf("LogHandler(): got %d annotations, want 2", len(as))
+	}
+	if a := as[0]; a.Title != "slow query" || a.Text != "db=users req.ms=820 req.user.id=7" || strings.Join(a.Tags, ",") != "log,warn" {
+		t.Errorf("LogHandler(): got annotation %+v", a)
+	}
+	if a := as[1]; a.Title != "cannot connect" || a.Text != "err=timeout" {
+		t.Errorf("LogHandler(): got annotation %+v", a)
+	}
+
+	if n := strings.Count(out.String(), "\n"); n != 2 || !strings.Contains(out.String(), "req.ms=820") {
+		t.Errorf("LogHandler(): next handler got\n%s", &out)
+	}
+}
+
+func TestDashboard_LogHandlerStop(t *testing.T) {
+	d := newTestDashboard()
+	_, stop := d.LogHandler(nil, time.Minute, 5*time.Millisecond, nil)
+	time.Sleep(20 * time.Millisecond)
+	stop()
+
+	m, err := d.Metric("log.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := m.Len()
+	time.Sleep(20 * time.Millisecond)
+	if m.Len() != n {
+		t.Errorf("LogHandler(): still recording after stop")
+	}
+}
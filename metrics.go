@@ -2,8 +2,10 @@ package grada
 
 import (
 	"errors"
-	"sort"
+	"runtime"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -20,20 +22,79 @@ type Count struct {
 // dashboard panel can request at regular intervals.
 // Each Metric has a name that Grafana uses for selecting the desired data stream.
 // See Dashboard.CreateMetric().
+//
+// The buffer is always kept in timestamp order. Counts that arrive out of order
+// (see AddWithTime() and AddCount()) are inserted at their proper position.
+// When the buffer is full, every new Count evicts the one with the oldest timestamp.
+//
+// A Metric is safe for concurrent use. On machines with more than one CPU,
+// new Counts first go into one of several small ingestion buffers (shards),
+// chosen round-robin, and reach the main buffer in batches, so that many
+// goroutines can add data without contending for a single lock, and without
+// waiting for Grafana queries to complete. The goroutines still share the
+// counter that selects the shard, but only for a single atomic increment.
 type Metric struct {
-	m        sync.Mutex
-	list     []Count
-	head     int
-	unsorted bool // AddWithTime() and AddCount() do not add in a sorted manner.
+	m      sync.Mutex // protects data and batch
+	data   store
+	batch  []Count // scratch buffer for flushing the shards
+	shards []shard
+	next   atomic.Uint32 // selects the shard for the next Count, round-robin
+}
+
+// shard is an ingestion buffer of a Metric.
+type shard struct {
+	m      sync.Mutex
+	counts []Count
+	_      [32]byte // keep shards on separate cache lines
+}
+
+const (
+	// shardFlushSize is the number of Counts at which a shard tries
+	// to move its Counts to the main buffer.
+	shardFlushSize = 256
+	// shardMaxSize is the number of Counts at which a shard waits for
+	// the main buffer to become available.
+	shardMaxSize = 16384
+)
+
+// store is the storage backend of a Metric.
+//
+// A store holds at most size() Counts in timestamp order. When the store is full,
+// insert evicts the Count with the oldest timestamp, or discards the new Count
+// if it is older than all Counts in the store.
+//
+// A store is not safe for concurrent use; the Metric's mutex protects it.
+type store interface {
+	insert(c Count)
+	// insertSorted inserts counts, which must be in time order.
+	insertSorted(counts []Count)
+	len() int
+	size() int
+	// resize changes the size of the store, keeping the newest Counts.
+	resize(size int)
+	// appendRange appends all Counts with from < T < to to dst, in time order.
+	appendRange(dst []Count, from, to time.Time) []Count
+	// last returns the newest Count, or false if the store is empty.
+	last() (Count, bool)
+}
+
+// newMetric creates a Metric that stores its data in data,
+// with as many ingestion buffers as GOMAXPROCS. On a single CPU, ingestion
+// buffers do not pay off, and Counts go straight to the main buffer.
+func newMetric(data store) *Metric {
+	g := &Metric{
+		data: data,
+	}
+	if procs := runtime.GOMAXPROCS(0); procs > 1 {
+		g.shards = make([]shard, procs)
+	}
+	return g
 }
 
 // Add a single value to the Metric buffer, along with the current time stamp.
 // When the buffer is full, every new value overwrites the oldest one.
 func (g *Metric) Add(n float64) {
-	g.m.Lock()
-	defer g.m.Unlock()
-	g.list[g.head] = Count{n, time.Now()}
-	g.head = (g.head + 1) % len(g.list)
+	g.AddCount(Count{n, time.Now()})
 }
 
 // AddWithTime adds a single (value, timestamp) tuple to the ring buffer.
@@ -42,51 +103,155 @@ func (g *Metric) AddWithTime(n float64, t time.Time) {
 }
 
 // AddCount adds a complete Count object to the metric data.
+// If the buffer is full and c is older than all Counts in the buffer,
+// c is discarded.
 func (g *Metric) AddCount(c Count) {
+	if len(g.shards) == 0 {
+		g.m.Lock()
+		g.data.insert(c)
+		g.m.Unlock()
+		return
+	}
+
+	s := &g.shards[int(g.next.Add(1))%len(g.shards)]
+	s.m.Lock()
+	s.counts = append(s.counts, c)
+	n := len(s.counts)
+	s.m.Unlock()
+	if n < shardFlushSize {
+		return
+	}
+
+	// The shard is due for a flush. Do not wait for the main buffer
+	// unless the shard has run full.
+	if n >= shardMaxSize {
+		g.m.Lock()
+	} else if !g.m.TryLock() {
+		return
+	}
+	g.flush()
+	g.m.Unlock()
+}
+
+// AddBatch adds several Counts at once. All Counts get inserted under
+// a single lock, so concurrent queries see either none or all of them.
+// The Counts do not need to be sorted. AddBatch does not modify counts.
+func (g *Metric) AddBatch(counts []Count) {
+	batch := slices.Clone(counts)
 	g.m.Lock()
 	defer g.m.Unlock()
-	g.unsorted = true
-	g.list[g.head] = c
-	g.head = (g.head + 1) % len(g.list)
+	g.flush()
+	g.insertBatch(batch)
 }
 
-// sort sorts the list of metrics by timestamp.
-// if the list is already sorted, sort() is a no-op.
-func (g *Metric) sort() {
-	if !g.unsorted {
-		return
-	}
+// Resize changes the size of the Metric's buffer. If the new buffer is
+// smaller than the number of Counts in the Metric, the oldest Counts
+// are discarded. A negative size counts as zero.
+func (g *Metric) Resize(size int) {
+	size = max(size, 0)
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.flush()
+	g.data.resize(size)
+}
+
+// ResizeFor changes the size of the Metric's buffer to hold the data points
+// of timeRange if they arrive every interval.
+// See Dashboard.CreateMetric() and Resize().
+func (g *Metric) ResizeFor(timeRange, interval time.Duration) {
+	g.Resize(bufSizeFor(timeRange, interval))
+}
+
+// Range returns all Counts of the Metric with a timestamp between
+// from and to (inclusive), in time order.
+func (g *Metric) Range(from, to time.Time) []Count {
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.flush()
+	return g.data.appendRange([]Count{}, from.Add(-1), to.Add(1))
+}
+
+// Last returns the Count with the newest timestamp.
+// If the Metric is empty, the second result is false.
+func (g *Metric) Last() (Count, bool) {
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.flush()
+	return g.data.last()
+}
+
+// Len returns the number of Counts in the Metric.
+func (g *Metric) Len() int {
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.flush()
+	return g.data.len()
+}
 
-	// the ring buffer is unsorted.
+// info returns a description of the Metric.
+func (g *Metric) info(target string) MetricInfo {
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.flush()
+	_, compressed := g.data.(*gorilla)
+	return MetricInfo{
+		Target:     target,
+		Size:       g.data.size(),
+		Len:        g.data.len(),
+		Compressed: compressed,
+	}
+}
 
-	// sooner implements the less func for sort.Slice.
-	sooner := func(i, j int) bool {
-		return g.list[i].T.UnixNano() < g.list[j].T.UnixNano()
+// flush moves the Counts of all shards into the main buffer.
+// Flushing all shards at once, rather than only the one that ran full,
+// keeps the number of out-of-order inserts low.
+// The caller must hold the lock.
+func (g *Metric) flush() {
+	g.batch = g.batch[:0]
+	for i := range g.shards {
+		s := &g.shards[i]
+		s.m.Lock()
+		g.batch = append(g.batch, s.counts...)
+		clear(s.counts)
+		s.counts = s.counts[:0]
+		s.m.Unlock()
+	}
+	if len(g.batch) > 0 {
+		g.insertBatch(g.batch)
+		clear(g.batch)
 	}
+}
 
-	sort.Slice(g.list, sooner)
-	g.head = 0
-	g.unsorted = false
+// insertBatch sorts counts and inserts them into the main buffer.
+// The caller must hold the lock.
+func (g *Metric) insertBatch(counts []Count) {
+	sooner := func(a, b Count) int {
+		return a.T.Compare(b.T)
+	}
+	if !slices.IsSortedFunc(counts, sooner) {
+		// Keep Counts with equal timestamps in the order of arrival,
+		// as the stores do.
+		slices.SortStableFunc(counts, sooner)
+	}
+	g.data.insertSorted(counts)
 }
 
 // fetchDatapoints is called by the Web API server.
-// It extracts all datapoints from g.list that fall within the time range [from, to],
+// It extracts all datapoints from the metric's store that fall within the time range [from, to],
 // with at most maxDataPoints items.
 func (g *Metric) fetchDatapoints(from, to time.Time, maxDataPoints int) *[]row {
 
+	// Stage 1: extract all data points within the given time range.
+	// The lock is only held while copying, so that queries do not block
+	// the flushes of the ingestion buffers for longer than necessary.
 	g.m.Lock()
-	defer g.m.Unlock()
-	length := len(g.list)
+	g.flush()
+	counts := g.data.appendRange(nil, from, to)
+	g.m.Unlock()
 
-	g.sort()
-
-	// Stage 1: extract all data points within the given time range.
-	pointsInRange := make([]row, 0, length)
-	for i := 0; i < length; i++ {
-		count := g.list[(i+g.head)%length] // wrap around
-		if count.T.After(from) && count.T.Before(to) {
-			pointsInRange = append(pointsInRange, row{count.N, count.T.UnixNano() / 1000000}) // need ms
-		}
+	pointsInRange := make([]row, len(counts))
+	for i, count := range counts {
+		pointsInRange[i] = row{count.N, count.T.UnixNano() / 1000000} // need ms
 	}
 
 	points := len(pointsInRange)
@@ -125,6 +290,32 @@ func (m *metrics) Get(target string) (*Metric, error) {
 	return mt, nil
 }
 
+// GetOrCreate gets the metric with name "target" from the Metrics map.
+// If a metric of that name does not exist, GetOrCreate creates a new Metric
+// with the given buffer size and adds it to the Metrics map.
+func (m *metrics) GetOrCreate(target string, size int) *Metric {
+	m.m.Lock()
+	defer m.m.Unlock()
+	mt, ok := m.metric[target]
+	if !ok {
+		mt = newMetric(newRing(size))
+		m.metric[target] = mt
+	}
+	return mt
+}
+
+// Targets returns the names of all metrics in the Metrics map, in sorted order.
+func (m *metrics) Targets() []string {
+	m.m.Lock()
+	targets := make([]string, 0, len(m.metric))
+	for t := range m.metric {
+		targets = append(targets, t)
+	}
+	m.m.Unlock()
+	slices.Sort(targets)
+	return targets
+}
+
 // Put adds a Metric to the Metrics map. Adding an already existing metric
 // is an error.
 func (m *metrics) Put(target string, metric *Metric) error {
@@ -152,13 +343,53 @@ func (m *metrics) Delete(target string) error {
 	return nil
 }
 
+// AddBatch adds Counts to several metrics at once, with the key of batch
+// being the target name. All metrics get locked during the update,
+// so that concurrent queries see either none or all of the new Counts.
+// If a metric does not exist, AddBatch returns an error and adds no Counts at all.
+func (m *metrics) AddBatch(batch map[string][]Count) error {
+	targets := make([]string, 0, len(batch))
+	for target := range batch {
+		targets = append(targets, target)
+	}
+	// A fixed locking order prevents deadlocks between concurrent batches.
+	slices.Sort(targets)
+
+	mts := make([]*Metric, len(targets))
+	for i, target := range targets {
+		mt, err := m.Get(target)
+		if err != nil {
+			return err
+		}
+		mts[i] = mt
+	}
+
+	locked := map[*Metric]bool{} // a Metric may be stored under more than one target
+	for i, mt := range mts {
+		if !locked[mt] {
+			mt.m.Lock()
+			defer mt.m.Unlock()
+			mt.flush()
+			locked[mt] = true
+		}
+		mt.insertBatch(slices.Clone(batch[targets[i]]))
+	}
+	return nil
+}
+
 // Create creates a new Metric with the given target name and buffer size
 // and adds it to the Metrics map.
 // If a metric for target "target" exists already, Create returns an error.
 func (m *metrics) Create(target string, size int) (*Metric, error) {
-	metric := &Metric{
-		list: make([]Count, size, size),
-	}
+	metric := newMetric(newRing(size))
+	err := m.Put(target, metric)
+	return metric, err
+}
+
+// CreateCompressed works like Create but the new Metric stores its data
+// in compressed form.
+func (m *metrics) CreateCompressed(target string, size int) (*Metric, error) {
+	metric := newMetric(newGorilla(size))
 	err := m.Put(target, metric)
 	return metric, err
 }
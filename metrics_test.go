@@ -1,6 +1,9 @@
 package grada
 
 import (
+	"math"
+	"runtime"
+	"slices"
 	"sync"
 	"testing"
 	"time"
@@ -8,49 +11,80 @@ import (
 	"github.com/google/go-cmp/cmp"
 )
 
+// counts returns all Counts of g in time order.
+func counts(g *Metric) []Count {
+	g.m.Lock()
+	defer g.m.Unlock()
+	g.flush()
+	return g.data.appendRange([]Count{}, time.Unix(0, math.MinInt64), time.Unix(0, math.MaxInt64))
+}
+
 func TestMetric_Add(t *testing.T) {
 	type fields struct {
 		list []Count
 		head int
+		n    int
 	}
 	type args struct {
 		n float64
 	}
+
+	past := time.Now().Add(-time.Minute)
+	t1 := past.Add(1 * time.Second)
+	t2 := past.Add(2 * time.Second)
+	t3 := past.Add(3 * time.Second)
+
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		newHead int
+		name   string
+		fields fields
+		args   args
+		want   []float64
 	}{
 		{
-			name: "target1",
+			name: "empty",
 			fields: fields{
-				list: []Count{{1, time.Now()}, {2, time.Now()}, {3, time.Now()}},
-				head: 1},
-			args:    args{n: 4},
-			newHead: 2,
+				list: make([]Count, 3),
+				head: 0,
+				n:    0},
+			args: args{n: 4},
+			want: []float64{4},
 		},
-
 		{
-			name: "target2",
+			name: "partiallyFilled",
 			fields: fields{
-				list: []Count{{4, time.Now()}, {5, time.Now()}, {6, time.Now()}},
-				head: 2},
-			args:    args{n: 7},
-			newHead: 0,
+				list: []Count{{2, t2}, {}, {1, t1}},
+				head: 2,
+				n:    2},
+			args: args{n: 4},
+			want: []float64{1, 2, 4},
+		},
+		{
+			name: "full",
+			fields: fields{
+				list: []Count{{3, t3}, {1, t1}, {2, t2}},
+				head: 1,
+				n:    3},
+			args: args{n: 7},
+			want: []float64{2, 3, 7},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			g := &Metric{
-				m:    sync.Mutex{},
-				list: tt.fields.list,
-				head: tt.fields.head,
+				data: &ring{
+					list: tt.fields.list,
+					head: tt.fields.head,
+					n:    tt.fields.n,
+				},
 			}
 			g.Add(tt.args.n)
-			if tt.fields.list[tt.fields.head].N != tt.args.n {
-				t.Errorf("failed adding %f to metric for target %s", tt.args.n, tt.name)
+			got := []float64{}
+			for _, c := range counts(g) {
+				got = append(got, c.N)
+			}
+			if !cmp.Equal(got, tt.want) {
+				t.Errorf("Metric.Add(%f): got %v, want %v", tt.args.n, got, tt.want)
 			}
 		})
 	}
@@ -60,107 +94,194 @@ func TestMetric_AddWithTime(t *testing.T) {
 	type fields struct {
 		list []Count
 		head int
+		n    int
 	}
 	type args struct {
 		n float64
 		t time.Time
 	}
+
+	t1 := time.Date(2017, time.October, 25, 11, 16, 51, 0, time.UTC)
+	t2 := time.Date(2017, time.October, 25, 11, 16, 52, 0, time.UTC)
+	t3 := time.Date(2017, time.October, 25, 11, 16, 53, 0, time.UTC)
+	t4 := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		newHead int
+		name   string
+		fields fields
+		args   args
+		want   []Count
 	}{
 		{
-			name: "target1",
+			name: "append",
 			fields: fields{
-				list: []Count{{1, time.Now()}, {2, time.Now()}, {3, time.Now()}},
-				head: 1},
-			args:    args{n: 4, t: time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)},
-			newHead: 2,
+				list: []Count{{1, t1}, {2, t2}, {}},
+				head: 0,
+				n:    2},
+			args: args{n: 4, t: t4},
+			want: []Count{{1, t1}, {2, t2}, {4, t4}},
 		},
-
 		{
-			name: "target2",
+			name: "insert",
 			fields: fields{
-				list: []Count{{4, time.Now()}, {5, time.Now()}, {6, time.Now()}},
-				head: 2},
-			args:    args{n: 7, t: time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)},
-			newHead: 0,
+				list: []Count{{}, {1, t1}, {4, t4}},
+				head: 1,
+				n:    2},
+			args: args{n: 3, t: t3},
+			want: []Count{{1, t1}, {3, t3}, {4, t4}},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			g := &Metric{
-				m:    sync.Mutex{},
-				list: tt.fields.list,
-				head: tt.fields.head,
+				data: &ring{
+					list: tt.fields.list,
+					head: tt.fields.head,
+					n:    tt.fields.n,
+				},
 			}
 			g.AddWithTime(tt.args.n, tt.args.t)
-			if tt.fields.list[tt.fields.head].N != tt.args.n {
-				t.Errorf("failed adding %f to metric for target %s", tt.args.n, tt.name)
-			}
-			if tt.fields.list[tt.fields.head].T != tt.args.t {
-				t.Errorf("failed adding time %s to metric for target %s", tt.args.t.String(), tt.name)
+			if got := counts(g); !cmp.Equal(got, tt.want) {
+				t.Errorf("Metric.AddWithTime(%f, %s):\ngot  %v\nwant %v", tt.args.n, tt.args.t, got, tt.want)
 			}
 		})
 	}
 }
 
 func TestMetric_AddCount(t *testing.T) {
-	type fields struct {
-		list []Count
-		head int
-	}
-	type args struct {
-		c Count
+	ts := func(s int) time.Time {
+		return time.Date(2017, time.October, 25, 11, 16, s, 0, time.UTC)
 	}
+
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		newHead int
+		name string
+		size int
+		add  []Count
+		want []Count
 	}{
 		{
-			name: "target1",
-			fields: fields{
-				list: []Count{{1, time.Now()}, {2, time.Now()}, {3, time.Now()}},
-				head: 1},
-			args:    args{c: Count{N: 4, T: time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)}},
-			newHead: 2,
+			name: "inOrder",
+			size: 3,
+			add:  []Count{{1, ts(1)}, {2, ts(2)}},
+			want: []Count{{1, ts(1)}, {2, ts(2)}},
 		},
-
 		{
-			name: "target2",
-			fields: fields{
-				list: []Count{{4, time.Now()}, {5, time.Now()}, {6, time.Now()}},
-				head: 2},
-			args:    args{c: Count{N: 7, T: time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)}},
-			newHead: 0,
+			name: "inOrderWrapAround",
+			size: 3,
+			add:  []Count{{1, ts(1)}, {2, ts(2)}, {3, ts(3)}, {4, ts(4)}, {5, ts(5)}},
+			want: []Count{{3, ts(3)}, {4, ts(4)}, {5, ts(5)}},
+		},
+		{
+			name: "outOfOrder",
+			size: 5,
+			add:  []Count{{4, ts(4)}, {3, ts(3)}, {5, ts(5)}, {1, ts(1)}, {2, ts(2)}},
+			want: []Count{{1, ts(1)}, {2, ts(2)}, {3, ts(3)}, {4, ts(4)}, {5, ts(5)}},
+		},
+		{
+			name: "outOfOrderEvictsOldest",
+			size: 3,
+			add:  []Count{{2, ts(2)}, {5, ts(5)}, {3, ts(3)}, {4, ts(4)}, {1, ts(1)}},
+			want: []Count{{3, ts(3)}, {4, ts(4)}, {5, ts(5)}},
+		},
+		{
+			name: "olderThanAllWhenFull",
+			size: 2,
+			add:  []Count{{2, ts(2)}, {3, ts(3)}, {1, ts(1)}},
+			want: []Count{{2, ts(2)}, {3, ts(3)}},
+		},
+		{
+			name: "equalTimestampsKeepArrivalOrder",
+			size: 3,
+			add:  []Count{{1, ts(1)}, {3, ts(3)}, {2, ts(1)}},
+			want: []Count{{1, ts(1)}, {2, ts(1)}, {3, ts(3)}},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			g := &Metric{
-				m:    sync.Mutex{},
-				list: tt.fields.list,
-				head: tt.fields.head,
-			}
-			g.AddCount(tt.args.c)
-			if got := tt.fields.list[tt.fields.head].N; got != tt.args.c.N {
-				t.Errorf("AddCount(%f, %s) failed for %s - got %f", tt.args.c.N, tt.args.c.T.String(), tt.name, got)
+			g := &Metric{data: newRing(tt.size)}
+			for _, c := range tt.add {
+				g.AddCount(c)
 			}
-			if got := tt.fields.list[tt.fields.head].T; got != tt.args.c.T {
-				t.Errorf("AddCount(%f, %s) failed for %s - got %v", tt.args.c.N, tt.args.c.T.String(), tt.name, got)
+			if got := counts(g); !cmp.Equal(got, tt.want) {
+				t.Errorf("Metric.AddCount():\ngot  %v\nwant %v\ndiff:\n%s", got, tt.want, cmp.Diff(got, tt.want))
 			}
 		})
 	}
 }
 
+// TestMetric_mixedAdd interleaves Add() with AddWithTime() calls
+// for timestamps in the past and in the future.
+func TestMetric_mixedAdd(t *testing.T) {
+	g := &Metric{data: newRing(4)}
+	now := time.Now()
+
+	g.AddWithTime(1, now.Add(-3*time.Hour))
+	g.Add(2)
+	g.AddWithTime(3, now.Add(time.Hour))
+	g.AddWithTime(4, now.Add(-2*time.Hour))
+	g.Add(5)                                // evicts 1
+	g.AddWithTime(6, now.Add(-4*time.Hour)) // older than all, discarded
+	g.AddWithTime(7, now.Add(2*time.Hour))  // evicts 4
+
+	got := []float64{}
+	for _, c := range counts(g) {
+		got = append(got, c.N)
+	}
+	want := []float64{2, 5, 3, 7}
+	if !cmp.Equal(got, want) {
+		t.Errorf("mixed Add/AddWithTime: got %v, want %v", got, want)
+	}
+}
+
+// TestMetric_concurrentAdd adds Counts from several goroutines while
+// fetching data points concurrently.
+func TestMetric_concurrentAdd(t *testing.T) {
+	const producers, perProducer = 8, 5000
+	start := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+
+	g := newMetric(newRing(producers * perProducer))
+	g.shards = make([]shard, 4) // regardless of the number of CPUs
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				g.fetchDatapoints(start, start.Add(time.Second), 100)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				g.AddWithTime(float64(p), start.Add(time.Duration(i*producers+p)*time.Millisecond))
+			}
+		}(p)
+	}
+	wg.Wait()
+	close(done)
+
+	got := counts(g)
+	if len(got) != producers*perProducer {
+		t.Fatalf("concurrent AddWithTime(): got %d Counts, want %d", len(got), producers*perProducer)
+	}
+	for i, c := range got {
+		if want := start.Add(time.Duration(i) * time.Millisecond); !c.T.Equal(want) || c.N != float64(i%producers) {
+			t.Fatalf("concurrent AddWithTime(): Count %d is %v, want {%d %v}", i, c, i%producers, want)
+		}
+	}
+}
+
 func TestMetric_fetchDatapoints(t *testing.T) {
 	type fields struct {
 		list []Count
 		head int
+		n    int
 	}
 
 	t1 := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
@@ -179,7 +300,7 @@ func TestMetric_fetchDatapoints(t *testing.T) {
 	}{
 		{
 			"fetchAll",
-			fields{[]Count{{3, t3}, {1, t1}, {2, t2}}, 1},
+			fields{[]Count{{3, t3}, {1, t1}, {2, t2}}, 1, 3},
 			time.Date(2017, time.October, 25, 11, 15, 54, 0, time.UTC),
 			time.Date(2017, time.October, 25, 11, 20, 54, 0, time.UTC),
 			3,
@@ -187,7 +308,7 @@ func TestMetric_fetchDatapoints(t *testing.T) {
 		},
 		{
 			"fetchTimeRange",
-			fields{[]Count{{3, t3}, {1, t1}, {2, t2}}, 1},
+			fields{[]Count{{3, t3}, {1, t1}, {2, t2}}, 1, 3},
 			time.Date(2017, time.October, 25, 11, 17, 00, 0, time.UTC),
 			time.Date(2017, time.October, 25, 11, 20, 54, 0, time.UTC),
 			3,
@@ -195,20 +316,30 @@ func TestMetric_fetchDatapoints(t *testing.T) {
 		},
 		{
 			"fetchMaxPoints",
-			fields{[]Count{{3, t3}, {1, t1}, {2, t2}}, 1},
+			fields{[]Count{{3, t3}, {1, t1}, {2, t2}}, 1, 3},
 			time.Date(2017, time.October, 25, 11, 15, 00, 0, time.UTC),
 			time.Date(2017, time.October, 25, 11, 20, 00, 0, time.UTC),
 			2,
 			&[]row{{1.0, t1ms}, {2.0, t2ms}},
 		},
+		{
+			"skipUnfilled",
+			fields{[]Count{{2, t2}, {}, {}, {1, t1}}, 3, 2},
+			time.Time{},
+			time.Date(2017, time.October, 25, 11, 20, 00, 0, time.UTC),
+			4,
+			&[]row{{1.0, t1ms}, {2.0, t2ms}},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			g := &Metric{
-				m:    sync.Mutex{},
-				list: tt.fields.list,
-				head: tt.fields.head,
+				data: &ring{
+					list: tt.fields.list,
+					head: tt.fields.head,
+					n:    tt.fields.n,
+				},
 			}
 			if got := g.fetchDatapoints(tt.from, tt.to, tt.max); !cmp.Equal(got, tt.want) {
 				t.Errorf("Metric.fetchDatapoints():\ngot  %#v,\nwant %#v\nDiff: %s", got, tt.want, cmp.Diff(got, tt.want))
@@ -229,7 +360,7 @@ func TestMetrics_Get(t *testing.T) {
 	t2 := time.Date(2017, time.October, 25, 11, 17, 54, 0, time.UTC)
 	t3 := time.Date(2017, time.October, 25, 11, 18, 54, 0, time.UTC)
 
-	metric := &Metric{sync.Mutex{}, []Count{{3, t3}, {1, t1}, {2, t2}}, 1, false}
+	metric := &Metric{data: &ring{list: []Count{{3, t3}, {1, t1}, {2, t2}}, head: 1, n: 3}}
 
 	tests := []struct {
 		name    string
@@ -264,6 +395,22 @@ func TestMetrics_Get(t *testing.T) {
 	}
 }
 
+func TestMetrics_GetOrCreate(t *testing.T) {
+	mt := &metrics{
+		metric: map[string]*Metric{},
+	}
+	got := mt.GetOrCreate("target1", 10)
+	if want, _ := mt.Get("target1"); got != want {
+		t.Errorf("Metrics.GetOrCreate(): got %p, want %p", got, want)
+	}
+	if again := mt.GetOrCreate("target1", 20); again != got {
+		t.Errorf("Metrics.GetOrCreate() again: got %p, want %p", again, got)
+	}
+	if size := got.data.size(); size != 10 {
+		t.Errorf("Metrics.GetOrCreate(): got size %d, want 10", size)
+	}
+}
+
 func TestMetrics_Put(t *testing.T) {
 	type fields struct {
 		metric map[string]*Metric
@@ -275,7 +422,7 @@ func TestMetrics_Put(t *testing.T) {
 	t1 := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
 	t2 := time.Date(2017, time.October, 25, 11, 17, 54, 0, time.UTC)
 	t3 := time.Date(2017, time.October, 25, 11, 18, 54, 0, time.UTC)
-	metric := &Metric{sync.Mutex{}, []Count{{3, t3}, {1, t1}, {2, t2}}, 1, false}
+	metric := &Metric{data: &ring{list: []Count{{3, t3}, {1, t1}, {2, t2}}, head: 1, n: 3}}
 
 	tests := []struct {
 		name    string
@@ -317,7 +464,7 @@ func TestMetrics_Delete(t *testing.T) {
 	t1 := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
 	t2 := time.Date(2017, time.October, 25, 11, 17, 54, 0, time.UTC)
 	t3 := time.Date(2017, time.October, 25, 11, 18, 54, 0, time.UTC)
-	metric := &Metric{sync.Mutex{}, []Count{{3, t3}, {1, t1}, {2, t2}}, 1, false}
+	metric := &Metric{data: &ring{list: []Count{{3, t3}, {1, t1}, {2, t2}}, head: 1, n: 3}}
 
 	tests := []struct {
 		name    string
@@ -382,116 +529,319 @@ func TestMetrics_Create(t *testing.T) {
 				t.Errorf("Metrics.Create() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr {
+				return
+			}
 			want := mt.metric[tt.args.target]
-			if !cmp.Equal(got, want, cmp.AllowUnexported((*got), (*got).m)) {
-				t.Errorf("Metrics.Create():\ngot  %v\nwant %v\ndiff:\n%s", got, want, cmp.Diff(got, want, cmp.AllowUnexported(*got, (*got).m)))
+			if got != want { // strict identity required
+				t.Errorf("Metrics.Create():\ngot  %p\nwant %p", got, want)
 			}
-			if cap(got.list) != tt.args.size {
-				t.Errorf("Metrics.Create(): got size %d, want %d", cap(got.list), tt.args.size)
+			if got.data.size() != tt.args.size {
+				t.Errorf("Metrics.Create(): got size %d, want %d", got.data.size(), tt.args.size)
 			}
 		})
 	}
 }
 
-func TestMetric_sort(t *testing.T) {
-	type fields struct {
-		list     []Count
-		head     int
-		unsorted bool
+// BenchmarkMetric_Add measures concurrent calls to Add(), with and without
+// a concurrent query that fetches all data points in a loop, for a Metric
+// with ingestion buffers ("sharded") and one without ("locked").
+func BenchmarkMetric_Add(b *testing.B) {
+	const size = 100000
+	metrics := []struct {
+		name string
+		new  func() *Metric
+	}{
+		{"locked", func() *Metric { return &Metric{data: newRing(size)} }},
+		{"sharded", func() *Metric { return &Metric{data: newRing(size), shards: make([]shard, runtime.GOMAXPROCS(0))} }},
+	}
+	for _, m := range metrics {
+		for _, query := range []bool{false, true} {
+			name := m.name
+			if query {
+				name += "+query"
+			}
+			b.Run(name, func(b *testing.B) {
+				g := m.new()
+				done := make(chan struct{})
+				if query {
+					go func() {
+						from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+						for {
+							select {
+							case <-done:
+								return
+							default:
+								g.fetchDatapoints(from, to, 1000)
+							}
+						}
+					}()
+				}
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						g.Add(1)
+					}
+				})
+				b.StopTimer()
+				close(done)
+			})
+		}
+	}
+}
+
+func TestMetric_AddBatch(t *testing.T) {
+	ts := func(s int) time.Time {
+		return time.Date(2017, time.October, 25, 11, 16, s, 0, time.UTC)
 	}
+
 	tests := []struct {
-		name   string
-		fields fields
-		want   fields
+		name  string
+		size  int
+		add   []Count
+		batch []Count
+		want  []Count
 	}{
 		{
-			name: "sorted",
-			fields: fields{
-				list: []Count{
-					Count{N: 1, T: time.Unix(1509369032, 630000001)},
-					Count{N: 2, T: time.Unix(1509369032, 630000002)},
-					Count{N: 3, T: time.Unix(1509369032, 630000003)},
-					Count{N: 4, T: time.Unix(1509369032, 630000004)},
-					Count{N: 5, T: time.Unix(1509369032, 630000005)},
-				},
-				head:     0,
-				unsorted: false,
-			},
-			want: fields{
-				[]Count{
-					Count{N: 1, T: time.Unix(1509369032, 630000001)},
-					Count{N: 2, T: time.Unix(1509369032, 630000002)},
-					Count{N: 3, T: time.Unix(1509369032, 630000003)},
-					Count{N: 4, T: time.Unix(1509369032, 630000004)},
-					Count{N: 5, T: time.Unix(1509369032, 630000005)},
-				},
-				0,
-				false,
-			},
+			name:  "empty",
+			size:  3,
+			batch: []Count{},
+			want:  []Count{},
 		},
 		{
-			name: "sortedButShifted",
-			fields: fields{
-				list: []Count{
-					Count{N: 4, T: time.Unix(1509369032, 630000004)},
-					Count{N: 5, T: time.Unix(1509369032, 630000005)},
-					Count{N: 1, T: time.Unix(1509369032, 630000001)},
-					Count{N: 2, T: time.Unix(1509369032, 630000002)},
-					Count{N: 3, T: time.Unix(1509369032, 630000003)},
-				},
-				head:     2,
-				unsorted: false,
-			},
-			want: fields{
-				[]Count{
-					Count{N: 4, T: time.Unix(1509369032, 630000004)},
-					Count{N: 5, T: time.Unix(1509369032, 630000005)},
-					Count{N: 1, T: time.Unix(1509369032, 630000001)},
-					Count{N: 2, T: time.Unix(1509369032, 630000002)},
-					Count{N: 3, T: time.Unix(1509369032, 630000003)},
-				},
-				2,
-				false,
-			},
+			name:  "sorted",
+			size:  5,
+			add:   []Count{{1, ts(1)}},
+			batch: []Count{{2, ts(2)}, {3, ts(3)}},
+			want:  []Count{{1, ts(1)}, {2, ts(2)}, {3, ts(3)}},
 		},
 		{
-			name: "unsorted",
-			fields: fields{
-				list: []Count{
-					Count{N: 4, T: time.Unix(1509369032, 630000004)},
-					Count{N: 3, T: time.Unix(1509369032, 630000003)},
-					Count{N: 5, T: time.Unix(1509369032, 630000005)},
-					Count{N: 1, T: time.Unix(1509369032, 630000001)},
-					Count{N: 2, T: time.Unix(1509369032, 630000002)},
-				},
-				head:     5,
-				unsorted: true,
-			},
-			want: fields{
-				[]Count{
-					Count{N: 1, T: time.Unix(1509369032, 630000001)},
-					Count{N: 2, T: time.Unix(1509369032, 630000002)},
-					Count{N: 3, T: time.Unix(1509369032, 630000003)},
-					Count{N: 4, T: time.Unix(1509369032, 630000004)},
-					Count{N: 5, T: time.Unix(1509369032, 630000005)},
-				},
-				0,
-				false,
-			},
+			name:  "unsorted",
+			size:  5,
+			add:   []Count{{2, ts(2)}, {5, ts(5)}},
+			batch: []Count{{4, ts(4)}, {1, ts(1)}, {3, ts(3)}},
+			want:  []Count{{1, ts(1)}, {2, ts(2)}, {3, ts(3)}, {4, ts(4)}, {5, ts(5)}},
+		},
+		{
+			name:  "evictsOldest",
+			size:  3,
+			add:   []Count{{2, ts(2)}, {5, ts(5)}},
+			batch: []Count{{4, ts(4)}, {1, ts(1)}, {3, ts(3)}},
+			want:  []Count{{3, ts(3)}, {4, ts(4)}, {5, ts(5)}},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			g := &Metric{
-				list:     tt.fields.list,
-				head:     tt.fields.head,
-				unsorted: tt.fields.unsorted,
-			}
-			g.sort()
-			got := g.list
-			want := tt.want.list
-			if !cmp.Equal(got, want) {
-				t.Errorf("Metric.sort(): got %v,\nwant %v\ndiff:\n%s", got, want, cmp.Diff(got, want))
+			g := newMetric(newRing(tt.size))
+			for _, c := range tt.add {
+				g.AddCount(c)
+			}
+			batch := slices.Clone(tt.batch)
+			g.AddBatch(batch)
+			if got := counts(g); !cmp.Equal(got, tt.want) {
+				t.Errorf("Metric.AddBatch():\ngot  %v\nwant %v\ndiff:\n%s", got, tt.want, cmp.Diff(got, tt.want))
+			}
+			if !cmp.Equal(batch, tt.batch) {
+				t.Errorf("Metric.AddBatch() modified its argument: got %v, want %v", batch, tt.batch)
+			}
+		})
+	}
+}
+
+// TestMetric_AddBatchStable checks that an unsorted batch keeps
+// Counts with equal timestamps in their order.
+func TestMetric_AddBatchStable(t *testing.T) {
+	ts := func(s int) time.Time {
+		return time.Date(2017, time.October, 25, 11, 16, s, 0, time.UTC)
+	}
+	// Enough Counts to make an unstable sort reorder equal timestamps.
+	var batch, early, late []Count
+	for i := 0; i < 100; i++ {
+		c := Count{float64(i), ts(2 - i%2)}
+		batch = append(batch, c)
+		if i%2 == 1 {
+			early = append(early, c)
+		} else {
+			late = append(late, c)
+		}
+	}
+	want := append(early, late...)
+
+	g := newMetric(newRing(len(batch)))
+	g.AddBatch(batch)
+	if got := counts(g); !cmp.Equal(got, want) {
+		t.Errorf("Metric.AddBatch():\ndiff:\n%s", cmp.Diff(got, want))
+	}
+}
+
+func TestMetrics_AddBatch(t *testing.T) {
+	t1 := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+	t2 := time.Date(2017, time.October, 25, 11, 17, 54, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		batch   map[string][]Count
+		want    map[string][]Count
+		wantErr bool
+	}{
+		{
+			"twoMetrics",
+			map[string][]Count{"target1": {{2, t2}, {1, t1}}, "target2": {{3, t1}}},
+			map[string][]Count{"target1": {{1, t1}, {2, t2}}, "target2": {{3, t1}}},
+			false,
+		},
+		{
+			"noSuchMetric",
+			map[string][]Count{"target1": {{1, t1}}, "target3": {{3, t1}}},
+			map[string][]Count{"target1": {}, "target2": {}},
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mt := &metrics{
+				metric: map[string]*Metric{},
+			}
+			mt.Create("target1", 10)
+			mt.Create("target2", 10)
+			err := mt.AddBatch(tt.batch)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Metrics.AddBatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for target, want := range tt.want {
+				g, _ := mt.Get(target)
+				if got := counts(g); !cmp.Equal(got, want) {
+					t.Errorf("Metrics.AddBatch(): %s got %v, want %v", target, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMetric_Resize(t *testing.T) {
+	ts := func(s int) time.Time {
+		return time.Date(2017, time.October, 25, 11, 16, s, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name    string
+		size    int
+		add     []Count
+		newSize int
+		addMore []Count
+		want    []Count
+	}{
+		{
+			name:    "shrink",
+			size:    4,
+			add:     []Count{{1, ts(1)}, {2, ts(2)}, {3, ts(3)}, {4, ts(4)}, {5, ts(5)}},
+			newSize: 2,
+			want:    []Count{{4, ts(4)}, {5, ts(5)}},
+		},
+		{
+			name:    "shrinkNotFull",
+			size:    5,
+			add:     []Count{{1, ts(1)}, {2, ts(2)}},
+			newSize: 3,
+			addMore: []Count{{3, ts(3)}, {4, ts(4)}},
+			want:    []Count{{2, ts(2)}, {3, ts(3)}, {4, ts(4)}},
+		},
+		{
+			name:    "grow",
+			size:    2,
+			add:     []Count{{1, ts(1)}, {2, ts(2)}, {3, ts(3)}},
+			newSize: 4,
+			addMore: []Count{{5, ts(5)}, {4, ts(4)}},
+			want:    []Count{{2, ts(2)}, {3, ts(3)}, {4, ts(4)}, {5, ts(5)}},
+		},
+		{
+			name:    "toZero",
+			size:    2,
+			add:     []Count{{1, ts(1)}, {2, ts(2)}},
+			newSize: 0,
+			addMore: []Count{{3, ts(3)}},
+			want:    []Count{},
+		},
+		{
+			name:    "negative",
+			size:    2,
+			add:     []Count{{1, ts(1)}, {2, ts(2)}},
+			newSize: -1,
+			addMore: []Count{{3, ts(3)}},
+			want:    []Count{},
+		},
+	}
+	for _, tt := range tests {
+		for _, s := range stores {
+			t.Run(tt.name+"/"+s.name, func(t *testing.T) {
+				g := newMetric(s.new(tt.size))
+				for _, c := range tt.add {
+					g.AddCount(c)
+				}
+				g.Resize(tt.newSize)
+				for _, c := range tt.addMore {
+					g.AddCount(c)
+				}
+				if got := counts(g); !cmp.Equal(got, tt.want) {
+					t.Errorf("Metric.Resize(%d):\ngot  %v\nwant %v", tt.newSize, got, tt.want)
+				}
+				if got := g.data.size(); got != max(tt.newSize, 0) {
+					t.Errorf("Metric.Resize(%d): got size %d", tt.newSize, got)
+				}
+			})
+		}
+	}
+}
+
+func TestMetric_Range(t *testing.T) {
+	ts := func(s int) time.Time {
+		return time.Date(2017, time.October, 25, 11, 16, s, 0, time.UTC)
+	}
+	add := []Count{{3, ts(3)}, {1, ts(1)}, {4, ts(4)}, {2, ts(2)}, {5, ts(5)}}
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []Count
+	}{
+		{"all", time.Time{}, ts(59), []Count{{1, ts(1)}, {2, ts(2)}, {3, ts(3)}, {4, ts(4)}, {5, ts(5)}}},
+		{"inclusive", ts(2), ts(4), []Count{{2, ts(2)}, {3, ts(3)}, {4, ts(4)}}},
+		{"single", ts(5), ts(5), []Count{{5, ts(5)}}},
+		{"none", ts(6), ts(10), []Count{}},
+	}
+	for _, tt := range tests {
+		for _, s := range stores {
+			t.Run(tt.name+"/"+s.name, func(t *testing.T) {
+				g := newMetric(s.new(10))
+				for _, c := range add {
+					g.AddCount(c)
+				}
+				if got := g.Range(tt.from, tt.to); !cmp.Equal(got, tt.want) {
+					t.Errorf("Metric.Range(%v, %v):\ngot  %v\nwant %v", tt.from, tt.to, got, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestMetric_LastAndLen(t *testing.T) {
+	t1 := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+	t2 := time.Date(2017, time.October, 25, 11, 17, 54, 0, time.UTC)
+
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			g := newMetric(s.new(10))
+			if c, ok := g.Last(); ok || g.Len() != 0 {
+				t.Errorf("empty Metric: got Last() = %v, %t and Len() = %d", c, ok, g.Len())
+			}
+			g.AddWithTime(2, t2)
+			g.AddWithTime(1, t1)
+			c, ok := g.Last()
+			if want := (Count{2, t2}); !ok || !cmp.Equal(c, want) {
+				t.Errorf("Metric.Last(): got %v, %t, want %v", c, ok, want)
+			}
+			if got := g.Len(); got != 2 {
+				t.Errorf("Metric.Len(): got %d, want 2", got)
 			}
 		})
 	}
@@ -0,0 +1,176 @@
+package grada
+
+// An OpenTelemetry metric exporter that writes into grada metrics.
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// otelExporter implements sdkmetric.Exporter.
+type otelExporter struct {
+	d        *Dashboard
+	size     int
+	prefix   string
+	shutdown atomic.Bool
+}
+
+// OTelExporter returns an OpenTelemetry metric exporter that adds
+// the measurements of OpenTelemetry instruments to grada metrics.
+// Use it with a periodic reader whose interval matches interval:
+//
+//	exp := d.OTelExporter("otel.", time.Hour, 10*time.Second)
+//	reader := sdkmetric.NewPeriodicReader(exp, sdkmetric.WithInterval(10*time.Second))
+//	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+//
+// The target name of a metric is the name of the instrument, preceded by
+// prefix, followed by the attributes of the data point, if any, in the form
+// `{key1="value1",key2="value2"}`, as with ScrapePrometheus(). The exporter
+// maps the instruments as follows:
+//
+//	counters               the increase per second
+//	up-down counters       the current value
+//	gauges                 the current value
+//	histograms             quantiles in targets with the suffixes .p50, .p90,
+//	                       .p99, and .max, as with CollectRuntimeMetrics()
+//
+// Histogram quantiles are the upper boundaries of the buckets that contain
+// them, capped at the largest value recorded. Exponential histograms and
+// summaries are skipped.
+//
+// timeRange and interval determine the buffer size of the metrics,
+// see CreateMetric(). Existing metrics of the same name are reused.
+func (d *Dashboard) OTelExporter(prefix string, timeRange, interval time.Duration) sdkmetric.Exporter {
+	return &otelExporter{d: d, size: bufSizeFor(timeRange, interval), prefix: prefix}
+}
+
+// Temporality selects delta temporality for counters and histograms,
+// so that each export contains the changes since the previous one.
+// Up-down counters stay cumulative, as their current value is what matters.
+func (e *otelExporter) Temporality(k sdkmetric.InstrumentKind) metricdata.Temporality {
+	switch k {
+	case sdkmetric.InstrumentKindCounter, sdkmetric.InstrumentKindObservableCounter, sdkmetric.InstrumentKindHistogram:
+		return metricdata.DeltaTemporality
+	}
+	return metricdata.CumulativeTemporality
+}
+
+// Aggregation selects the default aggregations.
+func (e *otelExporter) Aggregation(k sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(k)
+}
+
+// Export adds the data points of rm to the grada metrics.
+func (e *otelExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	if e.shutdown.Load() {
+		return errors.New("grada OTel exporter is shut down")
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Gauge[int64]:
+				exportPoints(e, m.Name, data.DataPoints, false)
+			case metricdata.Gauge[float64]:
+				exportPoints(e, m.Name, data.DataPoints, false)
+			case metricdata.Sum[int64]:
+				exportPoints(e, m.Name, data.DataPoints, data.IsMonotonic && data.Temporality == metricdata.DeltaTemporality)
+			case metricdata.Sum[float64]:
+				exportPoints(e, m.Name, data.DataPoints, data.IsMonotonic && data.Temporality == metricdata.DeltaTemporality)
+			case metricdata.Histogram[int64]:
+				exportHistogram(e, m.Name, data.DataPoints)
+			case metricdata.Histogram[float64]:
+				exportHistogram(e, m.Name, data.DataPoints)
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// ForceFlush does nothing, as Export adds all data right away.
+func (e *otelExporter) ForceFlush(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// Shutdown makes all further calls to Export fail.
+func (e *otelExporter) Shutdown(ctx context.Context) error {
+	e.shutdown.Store(true)
+	return ctx.Err()
+}
+
+// add adds v to the metric of the instrument name with the given attributes.
+func (e *otelExporter) add(name, suffix string, attrs attribute.Set, v float64, t time.Time) {
+	e.d.srv.metrics.GetOrCreate(e.prefix+name+suffix+attrLabels(attrs), e.size).AddWithTime(v, t)
+}
+
+// attrLabels formats attributes as labels, sorted by key.
+func attrLabels(attrs attribute.Set) string {
+	labels := make([]label, 0, attrs.Len())
+	for _, kv := range attrs.ToSlice() {
+		labels = append(labels, label{string(kv.Key), kv.Value.Emit()})
+	}
+	return formatLabels(labels)
+}
+
+// exportPoints adds the values of gauges and sums. If rate is true,
+// the values are deltas, and exportPoints adds their change per second.
+func exportPoints[N int64 | float64](e *otelExporter, name string, points []metricdata.DataPoint[N], rate bool) {
+	for _, p := range points {
+		v := float64(p.Value)
+		if rate {
+			secs := p.Time.Sub(p.StartTime).Seconds()
+			if secs <= 0 {
+				continue
+			}
+			v /= secs
+		}
+		e.add(name, "", p.Attributes, v, p.Time)
+	}
+}
+
+// exportHistogram adds the quantiles of histograms.
+func exportHistogram[N int64 | float64](e *otelExporter, name string, points []metricdata.HistogramDataPoint[N]) {
+	for _, p := range points {
+		max, hasMax := p.Max.Value()
+		histogramQuantiles(p.BucketCounts, p.Bounds, float64(max), hasMax, func(suffix string, v float64) {
+			e.add(name, suffix, p.Attributes, v, p.Time)
+		})
+	}
+}
+
+// histogramQuantiles calls f for each of the quantiles of a histogram with
+// explicit bucket boundaries, as OpenTelemetry defines it: bounds are the upper
+// boundaries of all buckets but the last, which reaches to +Inf.
+// If hasMax is true, max caps the quantiles, and is the value of the ".max"
+// quantile. histogramQuantiles does not call f for an empty histogram,
+// nor for quantiles without a finite value, such as all quantiles of
+// a histogram without bounds and without max.
+func histogramQuantiles(counts []uint64, bounds []float64, max float64, hasMax bool, f func(suffix string, v float64)) {
+	if len(counts) != len(bounds)+1 {
+		return
+	}
+	// quantile() expects the lower and upper boundaries of all buckets.
+	buckets := make([]float64, 0, len(bounds)+2)
+	buckets = append(buckets, math.Inf(-1))
+	buckets = append(buckets, bounds...)
+	buckets = append(buckets, math.Inf(1))
+	for _, q := range quantiles {
+		v, ok := quantile(counts, buckets, q.q)
+		if !ok {
+			return
+		}
+		if hasMax && (v > max || q.q == 1 || math.IsInf(v, 0)) {
+			v = max
+		}
+		if math.IsInf(v, 0) {
+			continue
+		}
+		f(q.suffix, v)
+	}
+}
@@ -0,0 +1,116 @@
+package grada
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func TestOTelExporter_Export(t *testing.T) {
+	ctx := context.Background()
+	d := newTestDashboard()
+	exp := d.OTelExporter("otel.", time.Minute, time.Second)
+	// A manual reader lets the test decide when to collect.
+	reader := sdkmetric.NewManualReader(sdkmetric.WithTemporalitySelector(exp.Temporality))
+	meter := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)).Meter("test")
+
+	requests, _ := meter.Int64Counter("requests")
+	queue, _ := meter.Int64UpDownCounter("queue.length")
+	temp, _ := meter.Float64Gauge("temperature")
+	latency, _ := meter.Float64Histogram("latency", metric.WithExplicitBucketBoundaries(10, 20, 50, 100))
+
+	get := metric.WithAttributes(attribute.String("method", "GET"), attribute.Int("code", 200))
+	requests.Add(ctx, 10, get)
+	requests.Add(ctx, 5, get)
+	queue.Add(ctx, 7)
+	queue.Add(ctx, -2)
+	temp.Record(ctx, 21.5)
+	for _, v := range []float64{5, 12, 15, 18, 30, 40, 42, 45, 48, 70} {
+		latency.Record(ctx, v)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.Export(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+
+	// The delta of the counter spans the time since the provider started.
+	var reqDP metricdata.DataPoint[int64]
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		if m.Name == "requests" {
+			reqDP = m.Data.(metricdata.Sum[int64]).DataPoints[0]
+		}
+	}
+	tests := []struct {
+		target string
+		want   float64
+	}{
+		{`otel.requests{code="200",method="GET"}`, 15 / reqDP.Time.Sub(reqDP.StartTime).Seconds()},
+		{"otel.queue.length", 5},
+		{"otel.temperature", 21.5},
+		{"otel.latency.p50", 50},
+		{"otel.latency.p90", 50},
+		{"otel.latency.p99", 70}, // capped at the max
+		{"otel.latency.max", 70},
+	}
+	for _, tt := range tests {
+		m, err := d.Metric(tt.target)
+		if err != nil {
+			t.Errorf("%s: %v", tt.target, err)
+			continue
+		}
+		if c, _ := m.Last(); c.N != tt.want {
+			t.Errorf("%s: got %f, want %f", tt.target, c.N, tt.want)
+		}
+	}
+
+	if err := exp.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := exp.Export(ctx, &rm); err == nil {
+		t.Error("Export() after Shutdown(): want error")
+	}
+}
+
+func Test_histogramQuantiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		counts []uint64
+		bounds []float64
+		max    float64
+		hasMax bool
+		want   map[string]float64
+	}{
+		{"noBoundsNoMax", []uint64{5}, nil, 0, false, map[string]float64{}},
+		{"noBounds", []uint64{5}, nil, 7, true, map[string]float64{".p50": 7, ".p90": 7, ".p99": 7, ".max": 7}},
+		{"noMax", []uint64{1, 1, 2}, []float64{10, 20}, 0, false, map[string]float64{".p50": 20, ".p90": 20, ".p99": 20, ".max": 20}},
+		{"withMax", []uint64{1, 1, 2}, []float64{10, 20}, 25, true, map[string]float64{".p50": 20, ".p90": 20, ".p99": 20, ".max": 25}},
+		{"empty", []uint64{0, 0}, []float64{10}, 0, false, map[string]float64{}},
+		{"mismatch", []uint64{1}, []float64{10}, 0, false, map[string]float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]float64{}
+			histogramQuantiles(tt.counts, tt.bounds, tt.max, tt.hasMax, func(suffix string, v float64) {
+				got[suffix] = v
+			})
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for suffix, v := range tt.want {
+				if got[suffix] != v {
+					t.Errorf("got %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
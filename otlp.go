@@ -0,0 +1,432 @@
+package grada
+
+// A receiver for metrics that other processes push through OTLP/HTTP:
+// https://opentelemetry.io/docs/specs/otlp/#otlphttp
+//
+// The receiver decodes the Protocol Buffers messages of
+// opentelemetry/proto/collector/metrics/v1/metrics_service.proto by hand,
+// as it only needs a small part of them.
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Protocol Buffers wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// pbFields calls f for each field of the Protocol Buffers message b.
+// For varint and fixed-size fields, v is the value and data is nil.
+// For length-delimited fields, data is the content.
+func pbFields(b []byte, f func(num int, typ int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("invalid field key")
+		}
+		b = b[n:]
+		num, typ := int(key>>3), int(key&7)
+		var v uint64
+		var data []byte
+		switch typ {
+		case wireVarint:
+			v, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errors.New("invalid varint")
+			}
+			b = b[n:]
+		case wireFixed64:
+			if len(b) < 8 {
+				return errors.New("truncated fixed64")
+			}
+			v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b)-n) {
+				return errors.New("invalid length")
+			}
+			data, b = b[n:n+int(l)], b[n+int(l):]
+		case wireFixed32:
+			if len(b) < 4 {
+				return errors.New("truncated fixed32")
+			}
+			v, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		default:
+			return errors.New("unsupported wire type")
+		}
+		if err := f(num, typ, v, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pbPacked calls f for each fixed64 value of a repeated field, which
+// can be packed into one length-delimited field or come as separate fields.
+func pbPacked(typ int, v uint64, data []byte, f func(uint64)) error {
+	if typ == wireFixed64 {
+		f(v)
+		return nil
+	}
+	if typ != wireBytes || len(data)%8 != 0 {
+		return errors.New("invalid packed fixed64 field")
+	}
+	for i := 0; i < len(data); i += 8 {
+		f(binary.LittleEndian.Uint64(data[i:]))
+	}
+	return nil
+}
+
+// The kinds of OTLP metrics that the receiver understands
+const (
+	otlpGauge = iota + 1
+	otlpSum
+	otlpHistogram
+)
+
+// OTLP aggregation temporalities
+const (
+	otlpDelta      = 1
+	otlpCumulative = 2
+)
+
+// otlpMetric is a decoded OTLP metric.
+type otlpMetric struct {
+	name        string
+	kind        int
+	temporality int
+	monotonic   bool
+	points      []otlpPoint
+}
+
+// otlpPoint is a decoded NumberDataPoint or HistogramDataPoint.
+type otlpPoint struct {
+	labels   []label
+	start, t time.Time
+	value    float64
+	counts   []uint64
+	bounds   []float64
+	max      float64
+	hasMax   bool
+}
+
+// decodeMetricsRequest decodes an ExportMetricsServiceRequest.
+// The attribute service.name of a resource becomes a label of all data points
+// of the resource.
+func decodeMetricsRequest(b []byte) ([]otlpMetric, error) {
+	var metrics []otlpMetric
+	err := pbFields(b, func(num, typ int, _ uint64, data []byte) error {
+		if num != 1 || typ != wireBytes {
+			return nil
+		}
+		// ResourceMetrics
+		var service []label
+		var scopes [][]byte
+		err := pbFields(data, func(num, typ int, _ uint64, data []byte) error {
+			switch {
+			case num == 1 && typ == wireBytes: // Resource
+				return pbFields(data, func(num, typ int, _ uint64, data []byte) error {
+					if num != 1 || typ != wireBytes {
+						return nil
+					}
+					l, err := decodeKeyValue(data)
+					if err == nil && l.name == "service.name" {
+						service = []label{l}
+					}
+					return err
+				})
+			case num == 2 && typ == wireBytes: // ScopeMetrics
+				scopes = append(scopes, data)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, scope := range scopes {
+			err := pbFields(scope, func(num, typ int, _ uint64, data []byte) error {
+				if num != 2 || typ != wireBytes {
+					return nil
+				}
+				m, err := decodeMetric(data, service)
+				if err == nil && m.kind != 0 {
+					metrics = append(metrics, m)
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return metrics, err
+}
+
+// decodeMetric decodes a Metric. Metrics of kinds other than gauge, sum,
+// and histogram have kind 0.
+func decodeMetric(b []byte, service []label) (otlpMetric, error) {
+	var m otlpMetric
+	err := pbFields(b, func(num, typ int, _ uint64, data []byte) error {
+		if typ != wireBytes {
+			return nil
+		}
+		switch num {
+		case 1:
+			m.name = string(data)
+			return nil
+		case 5:
+			m.kind = otlpGauge
+		case 7:
+			m.kind = otlpSum
+		case 9:
+			m.kind = otlpHistogram
+		default:
+			return nil
+		}
+		// Gauge, Sum, or Histogram
+		return pbFields(data, func(num, typ int, v uint64, data []byte) error {
+			switch {
+			case num == 1 && typ == wireBytes:
+				p, err := decodePoint(data, m.kind == otlpHistogram)
+				if err != nil {
+					return err
+				}
+				p.labels = append(p.labels, service...)
+				sortLabels(p.labels)
+				m.points = append(m.points, p)
+			case num == 2 && typ == wireVarint:
+				m.temporality = int(v)
+			case num == 3 && typ == wireVarint:
+				m.monotonic = v != 0
+			}
+			return nil
+		})
+	})
+	return m, err
+}
+
+// decodePoint decodes a NumberDataPoint, or a HistogramDataPoint if histogram is true.
+func decodePoint(b []byte, histogram bool) (otlpPoint, error) {
+	var p otlpPoint
+	attrs := 7
+	if histogram {
+		attrs = 9
+	}
+	err := pbFields(b, func(num, typ int, v uint64, data []byte) error {
+		switch {
+		case num == attrs && typ == wireBytes:
+			l, err := decodeKeyValue(data)
+			if err != nil {
+				return err
+			}
+			p.labels = append(p.labels, l)
+		case num == 2 && typ == wireFixed64:
+			p.start = time.Unix(0, int64(v))
+		case num == 3 && typ == wireFixed64:
+			p.t = time.Unix(0, int64(v))
+		case !histogram && num == 4 && typ == wireFixed64: // as_double
+			p.value = math.Float64frombits(v)
+		case !histogram && num == 6 && typ == wireFixed64: // as_int
+			p.value = float64(int64(v))
+		case histogram && num == 6:
+			return pbPacked(typ, v, data, func(n uint64) { p.counts = append(p.counts, n) })
+		case histogram && num == 7:
+			return pbPacked(typ, v, data, func(n uint64) { p.bounds = append(p.bounds, math.Float64frombits(n)) })
+		case histogram && num == 12 && typ == wireFixed64:
+			p.max, p.hasMax = math.Float64frombits(v), true
+		}
+		return nil
+	})
+	return p, err
+}
+
+// decodeKeyValue decodes a KeyValue with a string, bool, int, or double value.
+// Other values become empty strings.
+func decodeKeyValue(b []byte) (label, error) {
+	var l label
+	err := pbFields(b, func(num, typ int, _ uint64, data []byte) error {
+		switch {
+		case num == 1 && typ == wireBytes:
+			l.name = string(data)
+		case num == 2 && typ == wireBytes: // AnyValue
+			return pbFields(data, func(num, typ int, v uint64, data []byte) error {
+				switch {
+				case num == 1 && typ == wireBytes:
+					l.value = string(data)
+				case num == 2 && typ == wireVarint:
+					l.value = strconv.FormatBool(v != 0)
+				case num == 3 && typ == wireVarint:
+					l.value = strconv.FormatInt(int64(v), 10)
+				case num == 4 && typ == wireFixed64:
+					l.value = formatFloat(math.Float64frombits(v))
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return l, err
+}
+
+// otlpReceiver adds OTLP metrics to grada metrics. For cumulative sums and
+// histograms, it keeps the previous data point of each target.
+type otlpReceiver struct {
+	d      *Dashboard
+	size   int
+	prefix string
+
+	mu   sync.Mutex
+	prev map[string]otlpPoint
+}
+
+// OTLPHandler returns an HTTP handler that receives metrics through OTLP/HTTP
+// in the binary Protocol Buffers encoding, so that processes instrumented
+// with OpenTelemetry can push their metrics into the dashboard, for example
+// during development and testing. OTLP exporters send their metrics to the
+// path /v1/metrics; as the Grafana API server serves http.DefaultServeMux,
+// the handler can be added to this server by
+//
+//	http.Handle("/v1/metrics", d.OTLPHandler("otel.", time.Hour, 10*time.Second))
+//
+// The receiver maps the metrics like OTelExporter() does:
+//
+//	gauges                 the value
+//	monotonic sums         the increase per second
+//	other sums             the value
+//	histograms             quantiles in targets with the suffixes .p50, .p90,
+//	                       .p99, and .max
+//
+// The target names consist of prefix, the metric name, and the attributes
+// of the data point as labels. The attribute service.name of the resource
+// becomes a label as well. Rates and quantiles of cumulative sums and
+// histograms refer to the period since the previous data point; therefore,
+// they get recorded from the second data point on. As the max of a cumulative
+// histogram covers the whole time since its start, the quantiles of cumulative
+// histograms are bucket boundaries only, and so is .max.
+// Exponential histograms and summaries are skipped.
+//
+// timeRange and interval determine the buffer size of the metrics,
+// see CreateMetric(). Existing metrics of the same name are reused.
+// The handler accepts gzip-compressed requests. It does not support
+// the JSON encoding of OTLP.
+func (d *Dashboard) OTLPHandler(prefix string, timeRange, interval time.Duration) http.Handler {
+	rcv := &otlpReceiver{
+		d:      d,
+		size:   bufSizeFor(timeRange, interval),
+		prefix: prefix,
+		prev:   map[string]otlpPoint{},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/x-protobuf" {
+			http.Error(w, "unsupported content type "+ct, http.StatusUnsupportedMediaType)
+			return
+		}
+		var body io.Reader = http.MaxBytesReader(w, r.Body, maxWriteSize)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(body)
+			if err != nil {
+				writeError(w, err, "cannot decompress request body")
+				return
+			}
+			defer gz.Close()
+			body = io.LimitReader(gz, maxWriteSize)
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			writeError(w, err, "cannot read request body")
+			return
+		}
+		metrics, err := decodeMetricsRequest(b)
+		if err != nil {
+			writeError(w, err, "cannot decode request body")
+			return
+		}
+		rcv.receive(metrics)
+		// An empty ExportMetricsServiceResponse indicates success.
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// receive adds the data points of metrics to the grada metrics.
+func (rcv *otlpReceiver) receive(metrics []otlpMetric) {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	for _, m := range metrics {
+		for _, p := range m.points {
+			labels := formatLabels(p.labels)
+			target := rcv.prefix + m.name + labels
+			switch {
+			case m.kind == otlpGauge || (m.kind == otlpSum && !m.monotonic):
+				rcv.add(target, p.value, p.t)
+
+			case m.kind == otlpSum && m.temporality == otlpDelta:
+				if secs := p.t.Sub(p.start).Seconds(); secs > 0 {
+					rcv.add(target, p.value/secs, p.t)
+				}
+
+			case m.kind == otlpSum:
+				prev, ok := rcv.prev[target]
+				rcv.prev[target] = p
+				if secs := p.t.Sub(prev.t).Seconds(); ok && p.value >= prev.value && secs > 0 {
+					rcv.add(target, (p.value-prev.value)/secs, p.t)
+				}
+
+			case m.kind == otlpHistogram:
+				counts, max, hasMax := p.counts, p.max, p.hasMax
+				if m.temporality == otlpCumulative {
+					// The max of a cumulative histogram is the max since
+					// the start time, not the max of the period.
+					hasMax = false
+					prev, ok := rcv.prev[target]
+					rcv.prev[target] = p
+					if !ok || !grown(p.counts, prev.counts) {
+						continue
+					}
+					counts = diffCounts(p.counts, prev.counts)
+				}
+				histogramQuantiles(counts, p.bounds, max, hasMax, func(suffix string, v float64) {
+					rcv.add(rcv.prefix+m.name+suffix+labels, v, p.t)
+				})
+			}
+		}
+	}
+}
+
+// grown reports whether none of the counts is smaller than in prev.
+// Otherwise, the histogram has been reset.
+func grown(counts, prev []uint64) bool {
+	if len(counts) != len(prev) {
+		return false
+	}
+	for i := range counts {
+		if counts[i] < prev[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// add adds v to the target.
+func (rcv *otlpReceiver) add(target string, v float64, t time.Time) {
+	rcv.d.srv.metrics.GetOrCreate(target, rcv.size).AddWithTime(v, t)
+}
@@ -0,0 +1,192 @@
+package grada
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Helpers for encoding Protocol Buffers messages
+
+func pbKey(num, typ int) []byte {
+	return binary.AppendUvarint(nil, uint64(num<<3|typ))
+}
+
+func pbMsg(num int, fields ...[]byte) []byte {
+	data := bytes.Join(fields, nil)
+	return append(binary.AppendUvarint(pbKey(num, wireBytes), uint64(len(data))), data...)
+}
+
+func pbString(num int, s string) []byte {
+	return pbMsg(num, []byte(s))
+}
+
+func pbVarint(num int, v uint64) []byte {
+	return binary.AppendUvarint(pbKey(num, wireVarint), v)
+}
+
+func pbFixed64(num int, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(pbKey(num, wireFixed64), v)
+}
+
+func pbDouble(num int, f float64) []byte {
+	return pbFixed64(num, math.Float64bits(f))
+}
+
+func pbTime(num int, t time.Time) []byte {
+	return pbFixed64(num, uint64(t.UnixNano()))
+}
+
+func pbAttr(num int, key, value string) []byte {
+	return pbMsg(num, pbString(1, key), pbMsg(2, pbString(1, value)))
+}
+
+// otlpRequest encodes an ExportMetricsServiceRequest of a service with metrics,
+// which are Metric messages encoded as field 2 of ScopeMetrics.
+func otlpRequest(service string, metrics ...[]byte) []byte {
+	return pbMsg(1,
+		pbMsg(1, pbAttr(1, "service.name", service), pbMsg(1, pbString(1, "host"), pbMsg(2, pbVarint(3, 7)))),
+		pbMsg(2, append([][]byte{pbMsg(1, pbString(1, "scope"))}, metrics...)...),
+	)
+}
+
+func TestOTLPReceiver(t *testing.T) {
+	t0 := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+	t1 := t0.Add(10 * time.Second)
+	t2 := t1.Add(10 * time.Second)
+
+	// histogram encodes a histogram data point with bounds 10, 20, 50, packed and unpacked.
+	histogram := func(temporality uint64, t time.Time, counts ...uint64) []byte {
+		var packed []byte
+		for _, c := range counts {
+			packed = binary.LittleEndian.AppendUint64(packed, c)
+		}
+		return pbMsg(2, pbString(1, "latency"), pbMsg(9,
+			pbMsg(1, pbTime(2, t0), pbTime(3, t), pbMsg(6, packed),
+				pbDouble(7, 10), pbDouble(7, 20), pbDouble(7, 50), pbDouble(12, 42)),
+			pbVarint(2, temporality),
+		))
+	}
+	// sum encodes a sum data point.
+	sum := func(name string, temporality uint64, monotonic bool, start, t time.Time, v int64) []byte {
+		m := uint64(0)
+		if monotonic {
+			m = 1
+		}
+		return pbMsg(2, pbString(1, name), pbMsg(7,
+			pbMsg(1, pbAttr(7, "method", "GET"), pbTime(2, start), pbTime(3, t), pbFixed64(6, uint64(v))),
+			pbVarint(2, temporality), pbVarint(3, m),
+		))
+	}
+
+	d := newTestDashboard()
+	h := d.OTLPHandler("otel.", time.Minute, time.Second)
+	post := func(body []byte) {
+		t.Helper()
+		var gz bytes.Buffer
+		zw := gzip.NewWriter(&gz)
+		zw.Write(body)
+		zw.Close()
+		r := httptest.NewRequest("POST", "/v1/metrics", &gz)
+		r.Header.Set("Content-Type", "application/x-protobuf")
+		r.Header.Set("Content-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", w.Code, w.Body)
+		}
+	}
+
+	post(otlpRequest("shop",
+		pbMsg(2, pbString(1, "temperature"), pbMsg(5, pbMsg(1, pbTime(3, t1), pbDouble(4, 21.5)))),
+		sum("requests", otlpCumulative, true, t0, t1, 100),
+		sum("sent", otlpDelta, true, t0, t1, 50),
+		sum("queue", otlpCumulative, false, t0, t1, -3),
+		histogram(otlpDelta, t1, 1, 3, 5, 1),
+		pbMsg(2, pbString(1, "summary"), pbMsg(11)),
+	))
+	post(otlpRequest("shop",
+		sum("requests", otlpCumulative, true, t0, t2, 160),
+	))
+
+	tests := []struct {
+		target string
+		want   []float64
+	}{
+		{`otel.temperature{service_name="shop"}`, []float64{21.5}},
+		{`otel.requests{method="GET",service_name="shop"}`, []float64{6}}, // (160-100)/10s
+		{`otel.sent{method="GET",service_name="shop"}`, []float64{5}},     // 50/10s
+		{`otel.queue{method="GET",service_name="shop"}`, []float64{-3}},
+		{`otel.latency.p50{service_name="shop"}`, []float64{42}}, // bucket (20, 50], capped at max
+		{`otel.latency.p90{service_name="shop"}`, []float64{42}},
+		{`otel.latency.max{service_name="shop"}`, []float64{42}},
+	}
+	for _, tt := range tests {
+		m, err := d.Metric(tt.target)
+		if err != nil {
+			t.Errorf("%s: %v", tt.target, err)
+			continue
+		}
+		got := m.Range(t0, t2)
+		if len(got) != len(tt.want) || got[0].N != tt.want[0] {
+			t.Errorf("%s: got %v, want %v", tt.target, got, tt.want)
+		}
+	}
+	if _, err := d.Metric("otel.summary"); err == nil {
+		t.Errorf("summary metric got recorded")
+	}
+
+	// Cumulative histograms yield the quantiles of the difference.
+	d = newTestDashboard()
+	h = d.OTLPHandler("", time.Minute, time.Second)
+	post(otlpRequest("shop", histogram(otlpCumulative, t1, 1, 0, 0, 0)))
+	post(otlpRequest("shop", histogram(otlpCumulative, t2, 1, 5, 0, 0)))
+	m, err := d.Metric(`latency.p50{service_name="shop"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Range(t0, t2); len(got) != 1 || got[0].N != 20 {
+		t.Errorf("cumulative histogram: got %v, want p50 20", got)
+	}
+	// The max of 42 is the max since the start, so .max is the bucket boundary.
+	m, err = d.Metric(`latency.max{service_name="shop"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Range(t0, t2); len(got) != 1 || got[0].N != 20 {
+		t.Errorf("cumulative histogram: got %v, want max 20", got)
+	}
+}
+
+func TestDashboard_OTLPHandler_errors(t *testing.T) {
+	d := newTestDashboard()
+	h := d.OTLPHandler("", time.Minute, time.Second)
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        []byte
+		wantStatus  int
+	}{
+		{"get", "GET", "application/x-protobuf", nil, http.StatusMethodNotAllowed},
+		{"json", "POST", "application/json", []byte("{}"), http.StatusUnsupportedMediaType},
+		{"truncated", "POST", "application/x-protobuf", otlpRequest("x")[:10], http.StatusBadRequest},
+		{"empty", "POST", "application/x-protobuf", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/v1/metrics", bytes.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package grada
+
+// Code for exposing metrics in the Prometheus text exposition format:
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+//
+// Grada metrics have no labels. However, a target name of the form
+//
+//	name{label1="value1",label2="value2"}
+//
+// is exposed as a Prometheus metric with labels.
+
+import (
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// label is a Prometheus label.
+type label struct {
+	name, value string
+}
+
+// PrometheusHandler returns an HTTP handler that exposes the newest data point
+// of each metric in the Prometheus text exposition format, so that Prometheus
+// and compatible tools can scrape the metrics.
+//
+// As the Grafana API server serves http.DefaultServeMux, the handler can be
+// added to this server by
+//
+//	http.Handle("/metrics", d.PrometheusHandler())
+//
+// All metrics are exposed as gauges. Characters in target names that are not
+// valid in Prometheus metric names become underscores; for example, the target
+// "go.goroutines" becomes "go_goroutines". Targets of the form
+// `name{label="value",...}` are exposed with the given labels.
+// Metrics without data points are skipped, and so are targets that
+// become the same as a previous target after this replacement, such as
+// "a.b" after "a-b", and targets with a label name twice.
+// Labels are sorted by name, so that `a{x="1",y="2"}` and `a{y="2",x="1"}`
+// are the same series.
+func (d *Dashboard) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(d.prometheusText()))
+	})
+}
+
+// prometheusText renders the newest data point of each metric.
+// Each metric family appears in one block, sorted by name. If the names
+// and labels of several targets become the same after sanitizing,
+// only the first of these targets gets exposed.
+func (d *Dashboard) prometheusText() string {
+	families := map[string][]string{} // the sample lines per metric name
+	seen := map[string]bool{}         // the series exposed so far
+	for _, target := range d.srv.metrics.Targets() {
+		mt, err := d.srv.metrics.Get(target)
+		if err != nil {
+			continue // deleted in the meantime
+		}
+		c, ok := mt.Last()
+		if !ok {
+			continue
+		}
+		name, labels := parseTarget(target)
+		name = sanitizeName(name)
+		for i := range labels {
+			labels[i].name = sanitizeName(labels[i].name)
+		}
+		sortLabels(labels)
+		if duplicateLabels(labels) {
+			continue
+		}
+		series := name + formatLabels(labels)
+		if seen[series] {
+			continue
+		}
+		seen[series] = true
+		families[name] = append(families[name], series+" "+formatFloat(c.N)+"\n")
+	}
+
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString("# TYPE " + name + " gauge\n")
+		for _, line := range families[name] {
+			b.WriteString(line)
+		}
+	}
+	return b.String()
+}
+
+// formatLabels formats labels as `{label1="value1",...}`, or returns
+// an empty string if there are no labels.
+func formatLabels(labels []label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(sanitizeName(l.name) + `="` + escapeLabelValue(l.value) + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// duplicateLabels reports whether sorted labels contain a label name twice.
+func duplicateLabels(labels []label) bool {
+	for i := 1; i < len(labels); i++ {
+		if labels[i].name == labels[i-1].name {
+			return true
+		}
+	}
+	return false
+}
+
+// sortLabels sorts labels by name.
+func sortLabels(labels []label) {
+	slices.SortFunc(labels, func(a, b label) int {
+		return strings.Compare(a.name, b.name)
+	})
+}
+
+// parseTarget splits a target of the form `name{label="value",...}` into
+// the name and the labels. If target does not have this form, parseTarget
+// returns target unchanged and no labels.
+func parseTarget(target string) (string, []label) {
+	name, rest, ok := strings.Cut(target, "{")
+	if !ok || !strings.HasSuffix(rest, "}") {
+		return target, nil
+	}
+	labels, ok := parseLabels(strings.TrimSuffix(rest, "}"))
+	if !ok {
+		return target, nil
+	}
+	return name, labels
+}
+
+// parseLabels parses a comma-separated list of label="value" pairs.
+// Label values may contain the escape sequences \\, \", and \n.
+func parseLabels(s string) ([]label, bool) {
+	var labels []label
+	for {
+		s = strings.TrimLeft(s, " ")
+		if s == "" {
+			return labels, true
+		}
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok || !strings.HasPrefix(rest, `"`) {
+			return nil, false
+		}
+		var value strings.Builder
+		i := 1
+		for ; i < len(rest) && rest[i] != '"'; i++ {
+			if rest[i] == '\\' && i+1 < len(rest) {
+				i++
+				if rest[i] == 'n' {
+					value.WriteByte('\n')
+					continue
+				}
+			}
+			value.WriteByte(rest[i])
+		}
+		if i >= len(rest) {
+			return nil, false // missing closing quote
+		}
+		labels = append(labels, label{strings.TrimSpace(name), value.String()})
+		s = strings.TrimLeft(rest[i+1:], " ")
+		if s != "" && s[0] != ',' {
+			return nil, false
+		}
+		s = strings.TrimPrefix(s, ",")
+	}
+}
+
+// sanitizeName turns s into a valid Prometheus metric or label name
+// by replacing all invalid characters with underscores.
+func sanitizeName(s string) string {
+	if s == "" {
+		return "_"
+	}
+	b := []byte(s)
+	for i, c := range b {
+		valid := c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+// escapeLabelValue escapes backslashes, double quotes, and newlines.
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// formatFloat formats v as the exposition format expects it.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
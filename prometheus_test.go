@@ -0,0 +1,100 @@
+package grada
+
+import (
+	"io"
+	"math"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseTarget(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantName   string
+		wantLabels []label
+	}{
+		{"cpu.load", "cpu.load", nil},
+		{`http_requests{method="GET"}`, "http_requests", []label{{"method", "GET"}}},
+		{`http_requests{method="GET", code="200"}`, "http_requests", []label{{"method", "GET"}, {"code", "200"}}},
+		{`x{path="a,b",q="say \"hi\"\n"}`, "x", []label{{"path", "a,b"}, {"q", "say \"hi\"\n"}}},
+		{`x{}`, "x", nil},
+		{`x{method=GET}`, `x{method=GET}`, nil},
+		{`x{method="GET}`, `x{method="GET}`, nil},
+		{`x{a="1" b="2"}`, `x{a="1" b="2"}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			name, labels := parseTarget(tt.target)
+			if name != tt.wantName || !cmp.Equal(labels, tt.wantLabels, cmp.AllowUnexported(label{})) {
+				t.Errorf("parseTarget(%q) = %q, %v, want %q, %v", tt.target, name, labels, tt.wantName, tt.wantLabels)
+			}
+		})
+	}
+}
+
+func TestDashboard_PrometheusHandler(t *testing.T) {
+	d := newTestDashboard()
+	now := time.Now()
+	add := func(target string, values ...float64) {
+		m, err := d.CreateMetricWithBufSize(target, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, v := range values {
+			m.AddWithTime(v, now.Add(time.Duration(i)*time.Second))
+		}
+	}
+	add("go.goroutines", 5, 7)
+	add(`http_requests{method="GET"}`, 10)
+	add(`http_requests{method="POST",path="/a\"b"}`, 2)
+	add("empty")
+	add("9lives", math.Inf(1))
+	add("temp", 0.25, math.NaN())
+
+	rec := httptest.NewRecorder()
+	d.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	want := `# TYPE _lives gauge
+_lives +Inf
+# TYPE go_goroutines gauge
+go_goroutines 7
+# TYPE http_requests gauge
+http_requests{method="GET"} 10
+http_requests{method="POST",path="/a\"b"} 2
+# TYPE temp gauge
+temp NaN
+`
+	if got := string(body); got != want {
+		t.Errorf("PrometheusHandler():\ngot\n%s\nwant\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("PrometheusHandler(): got Content-Type %q", ct)
+	}
+}
+
+func TestDashboard_prometheusTextCollisions(t *testing.T) {
+	d := newTestDashboard()
+	for i, target := range []string{
+		"a-b", "a.b", "a.c", "a_b", `a.b{x="1"}`, `a_b{x="1"}`,
+		`d{x="1",y="2"}`, `d{y="2",x="1"}`, `c{x="1",x="2"}`, `c{l.a="1",l_a="2"}`,
+	} {
+		m, _ := d.CreateMetricWithBufSize(target, 10)
+		m.Add(float64(i))
+	}
+
+	want := `# TYPE a_b gauge
+a_b 0
+a_b{x="1"} 4
+# TYPE a_c gauge
+a_c 2
+# TYPE d gauge
+d{x="1",y="2"} 6
+`
+	if got := d.prometheusText(); got != want {
+		t.Errorf("prometheusText():\ngot\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,189 @@
+package grada
+
+// A scraper that reads metrics in the Prometheus text exposition format
+// from HTTP endpoints and adds them to grada metrics.
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scraper polls a single Prometheus endpoint.
+type scraper struct {
+	d      *Dashboard
+	size   int
+	url    string
+	prefix string
+	client *http.Client
+}
+
+// sample is a single sample of the Prometheus text format.
+type sample struct {
+	name   string
+	labels []label
+	value  float64
+	t      time.Time // zero if the sample has no timestamp
+}
+
+// ScrapePrometheus starts polling url every interval for metrics in the
+// Prometheus text exposition format, and adds each sample to a metric.
+//
+// The target name of a metric is the name of the sample, preceded by prefix,
+// followed by the labels of the sample, if any, in the form
+// `{label1="value1",label2="value2"}`, with the labels sorted by name.
+// For example, with prefix "sidecar.", the sample
+//
+//	http_requests_total{method="post",code="200"} 1027
+//
+// goes to target `sidecar.http_requests_total{code="200",method="post"}`.
+// Samples without a timestamp get the time of the scrape.
+//
+// Like Prometheus, the scraper records whether a scrape succeeded as 1 or 0
+// in the target prefix+"up".
+//
+// timeRange and interval determine the buffer size of the metrics,
+// see CreateMetric(). Existing metrics of the same name are reused.
+//
+// Scraping stops when the returned function is called,
+// or when the dashboard shuts down.
+func (d *Dashboard) ScrapePrometheus(url, prefix string, timeRange, interval time.Duration) (stop func()) {
+	s := &scraper{
+		d:      d,
+		size:   bufSizeFor(timeRange, interval),
+		url:    url,
+		prefix: prefix,
+		client: &http.Client{Timeout: interval},
+	}
+	return d.every(interval, func(t time.Time) bool {
+		up := 1.0
+		if err := s.scrape(t); err != nil {
+			up = 0
+		}
+		s.add("up", nil, up, t)
+		return true
+	})
+}
+
+// scrape fetches and parses the samples, and adds them to the metrics.
+func (s *scraper) scrape(now time.Time) error {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("cannot scrape " + s.url + ": " + resp.Status)
+	}
+
+	samples, err := parsePrometheusText(resp.Body)
+	if err != nil {
+		return err
+	}
+	for _, smp := range samples {
+		t := smp.t
+		if t.IsZero() {
+			t = now
+		}
+		s.add(smp.name, smp.labels, smp.value, t)
+	}
+	return nil
+}
+
+// add adds v to the metric of the sample name with the given labels.
+func (s *scraper) add(name string, labels []label, v float64, t time.Time) {
+	target := s.prefix + name + formatLabels(labels)
+	s.d.srv.metrics.GetOrCreate(target, s.size).AddWithTime(v, t)
+}
+
+// parsePrometheusText parses the Prometheus text exposition format.
+// Comments, including HELP and TYPE lines, are ignored.
+func parsePrometheusText(r io.Reader) ([]sample, error) {
+	var samples []sample
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		smp, err := parseSample(line)
+		if err != nil {
+			return nil, errors.New("line " + strconv.Itoa(n) + ": " + err.Error())
+		}
+		samples = append(samples, smp)
+	}
+	return samples, sc.Err()
+}
+
+// parseSample parses a sample line:
+//
+//	metric_name [ "{" label_name "=" `"` label_value `"` { "," ... } [ "," ] "}" ] value [ timestamp ]
+func parseSample(line string) (sample, error) {
+	var smp sample
+	end := strings.IndexAny(line, "{ \t")
+	if end <= 0 {
+		return smp, errors.New("invalid sample: " + line)
+	}
+	smp.name = line[:end]
+	rest := line[end:]
+
+	if rest[0] == '{' {
+		i := closingBrace(rest)
+		if i < 0 {
+			return smp, errors.New("missing '}': " + line)
+		}
+		labels, ok := parseLabels(rest[1:i])
+		if !ok {
+			return smp, errors.New("invalid labels: " + line)
+		}
+		sortLabels(labels)
+		smp.labels = labels
+		rest = rest[i+1:]
+	}
+
+	fields := strings.Fields(rest)
+	if len(fields) < 1 || len(fields) > 2 {
+		return smp, errors.New("invalid sample: " + line)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return smp, errors.New("invalid value: " + line)
+	}
+	smp.value = v
+	if len(fields) == 2 {
+		ms, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return smp, errors.New("invalid timestamp: " + line)
+		}
+		smp.t = time.UnixMilli(ms)
+	}
+	return smp, nil
+}
+
+// closingBrace returns the index of the '}' that closes the label block
+// at the start of s, or -1 if there is none. Braces within quoted label
+// values do not count.
+func closingBrace(s string) int {
+	quoted := false
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == '}' && !quoted:
+			return i
+		}
+	}
+	return -1
+}
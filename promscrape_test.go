@@ -0,0 +1,96 @@
+package grada
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parsePrometheusText(t *testing.T) {
+	text := `# HELP http_requests_total The total number of HTTP requests.
+# TYPE http_requests_total counter
+http_requests_total{method="post",code="200"} 1027 1395066363000
+http_requests_total{method="post",code="400"}    3 1395066363000
+
+msdos_file_access_time_seconds{path="C:\\DIR\\FILE.TXT",error="Cannot find file:\n\"FILE.TXT\""} 1.458255915e9
+weird{label="a}b",} -Inf
+temperature NaN
+`
+	got, err := parsePrometheusText(strings.NewReader(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.UnixMilli(1395066363000)
+	want := []sample{
+		{"http_requests_total", []label{{"code", "200"}, {"method", "post"}}, 1027, ts},
+		{"http_requests_total", []label{{"code", "400"}, {"method", "post"}}, 3, ts},
+		{"msdos_file_access_time_seconds", []label{{"error", "Cannot find file:\n\"FILE.TXT\""}, {"path", `C:\DIR\FILE.TXT`}}, 1.458255915e9, time.Time{}},
+		{"weird", []label{{"label", "a}b"}}, math.Inf(-1), time.Time{}},
+	}
+	if len(got) != len(want)+1 || got[4].name != "temperature" || !math.IsNaN(got[4].value) {
+		t.Fatalf("parsePrometheusText(): got %v", got)
+	}
+	if diff := cmp.Diff(got[:4], want, cmp.AllowUnexported(sample{}, label{})); diff != "" {
+		t.Errorf("parsePrometheusText(): diff:\n%s", diff)
+	}
+
+	for _, bad := range []string{
+		"no_value",
+		`missing_brace{a="1" 1`,
+		`bad_labels{a=1} 1`,
+		"bad_value one",
+		"bad_timestamp 1 yesterday",
+		"too_many_fields 1 2 3",
+	} {
+		if _, err := parsePrometheusText(strings.NewReader(bad)); err == nil {
+			t.Errorf("parsePrometheusText(%q): want error", bad)
+		}
+	}
+}
+
+func TestDashboard_ScrapePrometheus(t *testing.T) {
+	var fail atomic.Bool
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("# TYPE queue_length gauge\nqueue_length{queue=\"jobs\"} 42\n"))
+	}))
+	defer ts.Close()
+
+	d := newTestDashboard()
+	s := &scraper{d: d, size: 10, url: ts.URL, prefix: "app.", client: ts.Client()}
+	if err := s.scrape(time.Now()); err != nil {
+		t.Fatalf("scrape(): %v", err)
+	}
+	m, err := d.Metric(`app.queue_length{queue="jobs"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := m.Last(); !ok || c.N != 42 {
+		t.Errorf("scrape(): got %v, want 42", c)
+	}
+
+	fail.Store(true)
+	if err := s.scrape(time.Now()); err == nil {
+		t.Errorf("scrape(): want error for status 503")
+	}
+
+	stop := d.ScrapePrometheus(ts.URL, "app.", time.Minute, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	stop()
+	up, err := d.Metric("app.up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := up.Last(); !ok || c.N != 0 {
+		t.Errorf("ScrapePrometheus(): got up %v, want 0", c)
+	}
+}
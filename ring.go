@@ -0,0 +1,167 @@
+package grada
+
+import (
+	"sort"
+	"time"
+)
+
+// ring is the default store of a Metric: a ring buffer of Counts
+// that is kept in timestamp order.
+type ring struct {
+	list []Count
+	head int // index of the oldest Count
+	n    int // number of filled slots
+}
+
+// newRing creates a ring buffer that holds up to size Counts.
+func newRing(size int) *ring {
+	return &ring{
+		list: make([]Count, size, size),
+	}
+}
+
+func (r *ring) len() int {
+	return r.n
+}
+
+func (r *ring) size() int {
+	return len(r.list)
+}
+
+// resize changes the size of the buffer to size,
+// keeping the newest Counts that fit into the new buffer.
+func (r *ring) resize(size int) {
+	list := make([]Count, size, size)
+	n := min(r.n, size)
+	for i := range n {
+		list[i] = r.at(r.n - n + i)
+	}
+	r.list = list
+	r.head = 0
+	r.n = n
+}
+
+func (r *ring) last() (Count, bool) {
+	if r.n == 0 {
+		return Count{}, false
+	}
+	return r.at(r.n - 1), true
+}
+
+// at returns the i-th oldest Count of the buffer.
+// i must be in the range [0, r.n).
+func (r *ring) at(i int) Count {
+	return r.list[(r.head+i)%len(r.list)]
+}
+
+// set replaces the i-th oldest Count of the buffer.
+func (r *ring) set(i int, c Count) {
+	r.list[(r.head+i)%len(r.list)] = c
+}
+
+// search returns the index of the first Count that is newer than t.
+// If no such Count exists, search returns r.n.
+func (r *ring) search(t time.Time) int {
+	return sort.Search(r.n, func(i int) bool {
+		return r.at(i).T.After(t)
+	})
+}
+
+// insert adds c at the position determined by its timestamp.
+// Counts with identical timestamps remain in the order of arrival.
+func (r *ring) insert(c Count) {
+	size := len(r.list)
+	if size == 0 {
+		return
+	}
+
+	// Fast path: c is newer than all Counts in the buffer.
+	if r.n == 0 || !c.T.Before(r.at(r.n-1).T) {
+		r.push(c)
+		return
+	}
+
+	pos := r.search(c.T)
+	if r.n == size {
+		if pos == 0 {
+			// c is the oldest Count of all, hence it is the one to evict.
+			return
+		}
+		// Evict the oldest Count to make room.
+		r.head = (r.head + 1) % size
+		r.n--
+		pos--
+	}
+
+	// Shift all newer Counts by one slot to free the slot at pos.
+	for i := r.n; i > pos; i-- {
+		r.set(i, r.at(i-1))
+	}
+	r.set(pos, c)
+	r.n++
+}
+
+// push appends c, which must not be older than the newest Count in the buffer.
+// If the buffer is full, push overwrites the oldest Count.
+func (r *ring) push(c Count) {
+	size := len(r.list)
+	if r.n == size {
+		r.list[r.head] = c
+		r.head = (r.head + 1) % size
+		return
+	}
+	r.set(r.n, c)
+	r.n++
+}
+
+// insertSorted inserts counts, which must be in time order.
+// Rather than inserting the Counts one by one, insertSorted merges them
+// with all Counts in the buffer that are newer than the first one.
+func (r *ring) insertSorted(counts []Count) {
+	if len(r.list) == 0 || len(counts) == 0 {
+		return
+	}
+	pos := r.n
+	if r.n > 0 && counts[0].T.Before(r.at(r.n-1).T) {
+		pos = r.search(counts[0].T)
+	}
+	tail := make([]Count, r.n-pos)
+	for i := range tail {
+		tail[i] = r.at(pos + i)
+	}
+	r.n = pos
+	for _, c := range merge(tail, counts) {
+		r.push(c)
+	}
+}
+
+// merge merges two sorted slices of Counts into a new sorted slice.
+// For identical timestamps, the Counts of a come first.
+func merge(a, b []Count) []Count {
+	merged := make([]Count, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if b[0].T.Before(a[0].T) {
+			merged = append(merged, b[0])
+			b = b[1:]
+		} else {
+			merged = append(merged, a[0])
+			a = a[1:]
+		}
+	}
+	merged = append(merged, a...)
+	return append(merged, b...)
+}
+
+// appendRange appends all Counts with from < T < to to dst.
+// As the buffer is sorted, the scan can start at the first Count after "from"
+// and stop at the first Count at or after "to".
+func (r *ring) appendRange(dst []Count, from, to time.Time) []Count {
+	for i := r.search(from); i < r.n; i++ {
+		c := r.at(i)
+		if !c.T.Before(to) {
+			break
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
@@ -0,0 +1,199 @@
+package grada
+
+// A collector for metrics of the Go runtime, such as the number of goroutines,
+// the heap size, or GC pauses. The collector reads these metrics through
+// package runtime/metrics.
+
+import (
+	"math"
+	rtmetrics "runtime/metrics"
+	"time"
+)
+
+// runtimeMetric maps a metric of the Go runtime to one or more grada targets.
+// Depending on the kind of the runtime metric, the collector records
+//
+// * the value as is (gauges),
+// * the change per second (if rate is true), or
+// * quantiles of the values observed since the previous sample (histograms).
+type runtimeMetric struct {
+	name   string   // the name in package runtime/metrics
+	alt    []string // alternative names for older or newer Go versions
+	target string   // the target name, without prefix
+	rate   bool
+}
+
+var runtimeMetrics = []runtimeMetric{
+	{name: "/sched/goroutines:goroutines", target: "goroutines"},
+	{name: "/memory/classes/heap/objects:bytes", target: "heap.inuse"},
+	{name: "/gc/heap/allocs:bytes", target: "heap.allocrate", rate: true},
+	{name: "/sched/pauses/total/gc:seconds", alt: []string{"/gc/pauses:seconds"}, target: "gc.pause"},
+	{name: "/sched/latencies:seconds", target: "sched.latency"},
+}
+
+// quantiles are the quantiles recorded for histograms, along with
+// the suffix of their target names.
+var quantiles = []struct {
+	q      float64
+	suffix string
+}{
+	{0.5, ".p50"},
+	{0.9, ".p90"},
+	{0.99, ".p99"},
+	{1, ".max"},
+}
+
+// runtimeCollector samples runtime metrics and adds them to grada metrics.
+type runtimeCollector struct {
+	d       *Dashboard
+	size    int
+	samples []rtmetrics.Sample
+	metrics []runtimeMetric // the runtimeMetric for each sample
+	prefix  string
+
+	// The previous sample, for rates and histograms
+	prevT      time.Time
+	prevValues []float64
+	prevCounts [][]uint64
+}
+
+// CollectRuntimeMetrics starts collecting metrics of the Go runtime every interval.
+// It creates the following metrics, with each target name starting with prefix:
+//
+//	goroutines        number of live goroutines
+//	heap.inuse        bytes occupied by live and unswept heap objects
+//	heap.allocrate    bytes allocated on the heap per second
+//	gc.pause.p50      median GC pause time in seconds
+//	gc.pause.p90      90th percentile of GC pause times
+//	gc.pause.p99      99th percentile of GC pause times
+//	gc.pause.max      longest GC pause time
+//	sched.latency.*   the same quantiles for the time that goroutines
+//	                  spend in a runnable state before running
+//
+// For example, if prefix is "go.", the number of goroutines goes to target "go.goroutines".
+// timeRange and interval determine the buffer size of the metrics,
+// see CreateMetric(). Existing metrics of the same name are reused.
+//
+// The rate and the quantiles refer to the period since the previous sample;
+// therefore, they get recorded from the second sample on. Quantiles are only
+// recorded if there were any GC pauses or goroutine schedulings in that period.
+//
+// Collecting stops when the returned function is called,
+// or when the dashboard shuts down.
+func (d *Dashboard) CollectRuntimeMetrics(prefix string, timeRange, interval time.Duration) (stop func()) {
+	c := newRuntimeCollector(d, prefix, bufSizeFor(timeRange, interval))
+	c.collect(time.Now())
+	return d.every(interval, func(t time.Time) bool {
+		c.collect(t)
+		return true
+	})
+}
+
+// newRuntimeCollector creates a runtimeCollector for all runtime metrics that
+// the current Go version supports.
+func newRuntimeCollector(d *Dashboard, prefix string, size int) *runtimeCollector {
+	supported := map[string]bool{}
+	for _, desc := range rtmetrics.All() {
+		supported[desc.Name] = true
+	}
+	c := &runtimeCollector{d: d, size: size, prefix: prefix}
+	for _, rm := range runtimeMetrics {
+		for _, name := range append([]string{rm.name}, rm.alt...) {
+			if supported[name] {
+				c.samples = append(c.samples, rtmetrics.Sample{Name: name})
+				c.metrics = append(c.metrics, rm)
+				break
+			}
+		}
+	}
+	c.prevValues = make([]float64, len(c.samples))
+	c.prevCounts = make([][]uint64, len(c.samples))
+	return c
+}
+
+// collect reads the runtime metrics and adds them to the grada metrics
+// with timestamp now.
+func (c *runtimeCollector) collect(now time.Time) {
+	rtmetrics.Read(c.samples)
+	first := c.prevT.IsZero()
+	for i, s := range c.samples {
+		rm := c.metrics[i]
+		target := c.prefix + rm.target
+
+		switch s.Value.Kind() {
+		case rtmetrics.KindUint64, rtmetrics.KindFloat64:
+			var v float64
+			if s.Value.Kind() == rtmetrics.KindUint64 {
+				v = float64(s.Value.Uint64())
+			} else {
+				v = s.Value.Float64()
+			}
+			if rm.rate {
+				prev := c.prevValues[i]
+				c.prevValues[i] = v
+				if first {
+					continue
+				}
+				v = (v - prev) / now.Sub(c.prevT).Seconds()
+			}
+			c.d.srv.metrics.GetOrCreate(target, c.size).AddWithTime(v, now)
+
+		case rtmetrics.KindFloat64Histogram:
+			// The histogram is owned by the sample and gets overwritten
+			// by the next read, hence the copy of its counts.
+			h := s.Value.Float64Histogram()
+			prev := c.prevCounts[i]
+			c.prevCounts[i] = append(prev[:0:0], h.Counts...)
+			if first {
+				continue
+			}
+			counts := diffCounts(h.Counts, prev)
+			for _, q := range quantiles {
+				if v, ok := quantile(counts, h.Buckets, q.q); ok {
+					c.d.srv.metrics.GetOrCreate(target+q.suffix, c.size).AddWithTime(v, now)
+				}
+			}
+		}
+	}
+	c.prevT = now
+}
+
+// diffCounts returns the bucket counts of a histogram that were added since
+// the counts in prev.
+func diffCounts(counts, prev []uint64) []uint64 {
+	diff := make([]uint64, len(counts))
+	for i := range counts {
+		diff[i] = counts[i] - prev[i]
+	}
+	return diff
+}
+
+// quantile returns the q-quantile of the histogram with the given bucket counts
+// and bucket boundaries, or false if the histogram is empty.
+// As the exact distribution within a bucket is unknown, quantile returns
+// the upper boundary of the bucket, or the lower boundary for the last bucket
+// if its upper boundary is +Inf.
+func quantile(counts []uint64, buckets []float64, q float64) (float64, bool) {
+	var total uint64
+	for _, n := range counts {
+		total += n
+	}
+	if total == 0 {
+		return 0, false
+	}
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var cum uint64
+	for i, n := range counts {
+		cum += n
+		if cum >= rank {
+			if math.IsInf(buckets[i+1], 1) {
+				return buckets[i], true
+			}
+			return buckets[i+1], true
+		}
+	}
+	return buckets[len(buckets)-1], true
+}
@@ -0,0 +1,71 @@
+package grada
+
+import (
+	"math"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func Test_quantile(t *testing.T) {
+	buckets := []float64{0, 1, 2, 3, math.Inf(1)}
+
+	tests := []struct {
+		name   string
+		counts []uint64
+		q      float64
+		want   float64
+		wantOk bool
+	}{
+		{"empty", []uint64{0, 0, 0, 0}, 0.5, 0, false},
+		{"median", []uint64{1, 1, 1, 0}, 0.5, 2, true},
+		{"p90", []uint64{8, 1, 1, 0}, 0.9, 2, true},
+		{"p99", []uint64{8, 1, 1, 0}, 0.99, 3, true},
+		{"max", []uint64{0, 3, 0, 0}, 1, 2, true},
+		{"lowest", []uint64{5, 0, 0, 0}, 0, 1, true},
+		{"infinite", []uint64{0, 0, 0, 4}, 0.5, 3, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := quantile(tt.counts, buckets, tt.q)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("quantile(%v, %f) = %f, %t, want %f, %t", tt.counts, tt.q, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestDashboard_CollectRuntimeMetrics(t *testing.T) {
+	d := newTestDashboard()
+	stop := d.CollectRuntimeMetrics("go.", time.Minute, 10*time.Millisecond)
+	for i := 0; i < 5; i++ {
+		runtime.GC()
+		time.Sleep(20 * time.Millisecond)
+	}
+	stop()
+	stop() // no-op
+
+	for _, target := range []string{"go.goroutines", "go.heap.inuse", "go.heap.allocrate", "go.gc.pause.p50", "go.gc.pause.max"} {
+		m, err := d.Metric(target)
+		if err != nil {
+			t.Errorf("CollectRuntimeMetrics(): %v", err)
+			continue
+		}
+		if m.Len() < 2 {
+			t.Errorf("CollectRuntimeMetrics(): got %d data points for %s, want 2 or more", m.Len(), target)
+		}
+	}
+
+	m, err := d.Metric("go.goroutines")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := m.Last(); c.N < 1 {
+		t.Errorf("CollectRuntimeMetrics(): got %f goroutines", c.N)
+	}
+	n := m.Len()
+	time.Sleep(30 * time.Millisecond)
+	if m.Len() != n {
+		t.Errorf("CollectRuntimeMetrics(): collector still running after stop")
+	}
+}
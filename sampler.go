@@ -0,0 +1,30 @@
+package grada
+
+import "time"
+
+// Sample creates a metric for target and calls f every interval to add
+// a new data point to this metric. Use Sample for values that are best
+// read periodically, like the length of a queue or the size of a pool.
+//
+// timeRange and interval determine the buffer size of the metric,
+// see CreateMetric().
+//
+// Sampling stops when the metric gets deleted through DeleteMetric(),
+// or when the dashboard shuts down.
+//
+// Creating a metric for an existing target is an error.
+func (d *Dashboard) Sample(target string, timeRange, interval time.Duration, f func() float64) (*Metric, error) {
+	m, err := d.CreateMetric(target, timeRange, interval)
+	if err != nil {
+		return nil, err
+	}
+	d.every(interval, func(t time.Time) bool {
+		// The metric might have been deleted, and maybe replaced by a new one.
+		if mt, err := d.srv.metrics.Get(target); err != nil || mt != m {
+			return false
+		}
+		m.AddWithTime(f(), t)
+		return true
+	})
+	return m, nil
+}
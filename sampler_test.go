@@ -0,0 +1,67 @@
+package grada
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDashboard_Sample(t *testing.T) {
+	d := newTestDashboard()
+	var calls atomic.Int32
+	f := func() float64 {
+		return float64(calls.Add(1))
+	}
+
+	m, err := d.Sample("queue.length", time.Second, 5*time.Millisecond, f)
+	if err != nil {
+		t.Fatalf("Dashboard.Sample(): %v", err)
+	}
+	if _, err := d.Sample("queue.length", time.Second, 5*time.Millisecond, f); err == nil {
+		t.Errorf("Dashboard.Sample() for an existing target: want error")
+	}
+	if got := m.data.size(); got != 200 {
+		t.Errorf("Dashboard.Sample(): got buffer size %d, want %d", got, 200)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if m.Len() < 2 {
+		t.Errorf("Dashboard.Sample(): got %d data points, want 2 or more", m.Len())
+	}
+
+	// Deleting the metric stops the sampler.
+	d.DeleteMetric("queue.length")
+	time.Sleep(15 * time.Millisecond)
+	n := calls.Load()
+	time.Sleep(30 * time.Millisecond)
+	if got := calls.Load(); got != n {
+		t.Errorf("Dashboard.Sample(): sampler still running after DeleteMetric, %d more calls", got-n)
+	}
+}
+
+func TestDashboard_Shutdown(t *testing.T) {
+	d := newTestDashboard()
+	var calls atomic.Int32
+	if _, err := d.Sample("pool.size", time.Minute, 5*time.Millisecond, func() float64 {
+		calls.Add(1)
+		return 1
+	}); err != nil {
+		t.Fatal(err)
+	}
+	stop := d.CollectRuntimeMetrics("go.", time.Minute, 5*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Errorf("Dashboard.Shutdown(): %v", err)
+	}
+	n := calls.Load()
+	time.Sleep(20 * time.Millisecond)
+	if got := calls.Load(); got != n {
+		t.Errorf("Dashboard.Shutdown(): sampler still running")
+	}
+	stop() // must not block after shutdown
+	if err := d.Shutdown(context.Background()); err != nil {
+		t.Errorf("Dashboard.Shutdown() again: %v", err)
+	}
+}
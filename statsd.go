@@ -0,0 +1,259 @@
+package grada
+
+// A listener for metrics in the StatsD line protocol:
+// https://github.com/statsd/statsd/blob/master/docs/metric_types.md
+
+import (
+	"errors"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdListener aggregates StatsD packets and adds the aggregates to
+// grada metrics once per interval.
+type statsdListener struct {
+	d        *Dashboard
+	size     int
+	prefix   string
+	interval time.Duration
+
+	mu       sync.Mutex // protects the aggregates below
+	counters map[string]float64
+	gauges   map[string]float64
+	timers   map[string]*timerStats
+	sets     map[string]map[string]bool
+}
+
+// timerStats collects the values of a timer.
+type timerStats struct {
+	values []float64
+	count  float64 // the number of values, corrected by the sample rates
+}
+
+// statsdLine is a single parsed StatsD metric.
+type statsdLine struct {
+	name  string
+	value string // the raw value, as sets take any string
+	typ   string // c, g, ms, h, or s
+	rate  float64
+}
+
+// ListenStatsD starts listening for StatsD packets on the UDP address addr,
+// for example ":8125". It aggregates the received values and adds the results
+// to metrics every interval, with each target name starting with prefix:
+//
+//	counters (c)         name            the count per second, after correcting
+//	                                     for the sample rate
+//	gauges (g)           name            the current value; values prefixed with
+//	                                     + or - change the gauge relatively
+//	timers (ms, h)       name.count      the number of values per interval,
+//	                                     after correcting for the sample rate
+//	                     name.mean       the mean of all values
+//	                     name.p50        the median
+//	                     name.p90        the 90th percentile
+//	                     name.p99        the 99th percentile
+//	                     name.max        the largest value
+//	sets (s)             name            the number of unique values per interval
+//
+// Counters and gauges, once received, are recorded every interval,
+// counters with a value of 0 if there was no new packet. Timers and sets are
+// only recorded for intervals that saw new values. DogStatsD tags
+// (`|#tag:value`) are ignored. Lines that cannot be parsed are skipped.
+//
+// timeRange and interval determine the buffer size of the metrics,
+// see CreateMetric(). Existing metrics of the same name are reused.
+//
+// Listening stops when the returned function is called,
+// or when the dashboard shuts down.
+func (d *Dashboard) ListenStatsD(addr, prefix string, timeRange, interval time.Duration) (stop func(), err error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, errors.New("cannot listen for StatsD packets: " + err.Error())
+	}
+	return d.serveStatsD(conn, prefix, bufSizeFor(timeRange, interval), interval), nil
+}
+
+// serveStatsD reads StatsD packets from conn until stop is called
+// or the dashboard shuts down, and then closes conn.
+func (d *Dashboard) serveStatsD(conn net.PacketConn, prefix string, size int, interval time.Duration) (stop func()) {
+	l := newStatsdListener(d, prefix, size, interval)
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	shutdown := d.shutdownChan()
+	d.wg.Add(2)
+	go func() {
+		defer d.wg.Done()
+		defer close(stopped)
+		buf := make([]byte, 65535)
+		for {
+			n, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+				continue
+			}
+			l.handle(string(buf[:n]))
+		}
+	}()
+	go func() {
+		defer d.wg.Done()
+		select {
+		case <-done:
+		case <-shutdown:
+		}
+		conn.Close()
+	}()
+
+	stopFlush := d.every(interval, func(t time.Time) bool {
+		l.flush(t)
+		return true
+	})
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stopFlush()
+			close(done)
+		})
+		<-stopped
+	}
+}
+
+// newStatsdListener creates a statsdListener with empty aggregates.
+func newStatsdListener(d *Dashboard, prefix string, size int, interval time.Duration) *statsdListener {
+	return &statsdListener{
+		d:        d,
+		size:     size,
+		prefix:   prefix,
+		interval: interval,
+		counters: map[string]float64{},
+		gauges:   map[string]float64{},
+		timers:   map[string]*timerStats{},
+		sets:     map[string]map[string]bool{},
+	}
+}
+
+// handle parses a packet of one or more lines and aggregates its values.
+func (l *statsdListener) handle(packet string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range strings.Split(packet, "\n") {
+		sl, ok := parseStatsdLine(line)
+		if !ok {
+			continue
+		}
+		if sl.typ == "s" {
+			if l.sets[sl.name] == nil {
+				l.sets[sl.name] = map[string]bool{}
+			}
+			l.sets[sl.name][sl.value] = true
+			continue
+		}
+		v, err := strconv.ParseFloat(sl.value, 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		switch sl.typ {
+		case "c":
+			l.counters[sl.name] += v / sl.rate
+		case "g":
+			if sl.value[0] == '+' || sl.value[0] == '-' {
+				l.gauges[sl.name] += v
+			} else {
+				l.gauges[sl.name] = v
+			}
+		case "ms", "h":
+			ts := l.timers[sl.name]
+			if ts == nil {
+				ts = &timerStats{}
+				l.timers[sl.name] = ts
+			}
+			ts.values = append(ts.values, v)
+			ts.count += 1 / sl.rate
+		}
+	}
+}
+
+// parseStatsdLine parses a line of the form
+//
+//	name:value|type[|@rate][|#tags]
+func parseStatsdLine(line string) (statsdLine, bool) {
+	sl := statsdLine{rate: 1}
+	line = strings.TrimSpace(line)
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return sl, false
+	}
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 || fields[0] == "" {
+		return sl, false
+	}
+	sl.name, sl.value, sl.typ = name, fields[0], fields[1]
+	switch sl.typ {
+	case "c", "g", "ms", "h", "s":
+	default:
+		return sl, false
+	}
+	for _, f := range fields[2:] {
+		if strings.HasPrefix(f, "@") {
+			rate, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return sl, false
+			}
+			sl.rate = rate
+		}
+	}
+	return sl, true
+}
+
+// flush adds the aggregates of the past interval to the metrics
+// with timestamp now, and resets counters, timers, and sets.
+func (l *statsdListener) flush(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for name, v := range l.counters {
+		l.add(name, v/l.interval.Seconds(), now)
+		l.counters[name] = 0
+	}
+	for name, v := range l.gauges {
+		l.add(name, v, now)
+	}
+	for name, ts := range l.timers {
+		slices.Sort(ts.values)
+		var sum float64
+		for _, v := range ts.values {
+			sum += v
+		}
+		l.add(name+".count", ts.count, now)
+		l.add(name+".mean", sum/float64(len(ts.values)), now)
+		for _, q := range quantiles {
+			l.add(name+q.suffix, percentile(ts.values, q.q), now)
+		}
+		delete(l.timers, name)
+	}
+	for name, set := range l.sets {
+		l.add(name, float64(len(set)), now)
+		delete(l.sets, name)
+	}
+}
+
+// add adds v to the target for name.
+func (l *statsdListener) add(name string, v float64, now time.Time) {
+	l.d.srv.metrics.GetOrCreate(l.prefix+name, l.size).AddWithTime(v, now)
+}
+
+// percentile returns the q-quantile of the sorted values
+// by the nearest-rank method.
+func percentile(sorted []float64, q float64) float64 {
+	rank := int(math.Ceil(q * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,113 @@
+package grada
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_parseStatsdLine(t *testing.T) {
+	tests := []struct {
+		line   string
+		want   statsdLine
+		wantOk bool
+	}{
+		{"requests:1|c", statsdLine{"requests", "1", "c", 1}, true},
+		{"requests:3|c|@0.1", statsdLine{"requests", "3", "c", 0.1}, true},
+		{"queue.len:-2|g", statsdLine{"queue.len", "-2", "g", 1}, true},
+		{"latency:320|ms|@0.5|#route:/api", statsdLine{"latency", "320", "ms", 0.5}, true},
+		{"users:alice|s", statsdLine{"users", "alice", "s", 1}, true},
+		{"", statsdLine{}, false},
+		{"no_type:1", statsdLine{}, false},
+		{":1|c", statsdLine{}, false},
+		{"empty:|c", statsdLine{}, false},
+		{"unknown:1|x", statsdLine{}, false},
+		{"bad_rate:1|c|@2", statsdLine{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, ok := parseStatsdLine(tt.line)
+			if ok != tt.wantOk {
+				t.Fatalf("parseStatsdLine(%q): got ok %t, want %t", tt.line, ok, tt.wantOk)
+			}
+			if ok && got != tt.want {
+				t.Errorf("parseStatsdLine(%q): got %v, want %v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatsdListener_flush(t *testing.T) {
+	d := newTestDashboard()
+	// A long interval, so that only the final flush below records data.
+	l := newStatsdListener(d, "sd.", 10, 2*time.Second)
+	l.handle("hits:4|c\nhits:1|c|@0.5\nbogus\ntemp:20|g\ntemp:+1.5|g")
+	l.handle("rt:10|ms\nrt:30|ms|@0.5\nrt:20|ms\nrt:40|ms\nvisitors:a|s\nvisitors:b|s\nvisitors:a|s")
+	l.flush(time.Now())
+
+	tests := []struct {
+		target string
+		want   float64
+	}{
+		{"sd.hits", 3}, // (4 + 1/0.5) / 2s
+		{"sd.temp", 21.5},
+		{"sd.rt.count", 5},
+		{"sd.rt.mean", 25},
+		{"sd.rt.p50", 20},
+		{"sd.rt.p90", 40},
+		{"sd.rt.max", 40},
+		{"sd.visitors", 2},
+	}
+	for _, tt := range tests {
+		m, err := d.Metric(tt.target)
+		if err != nil {
+			t.Errorf("%s: %v", tt.target, err)
+			continue
+		}
+		if c, _ := m.Last(); c.N != tt.want {
+			t.Errorf("%s: got %f, want %f", tt.target, c.N, tt.want)
+		}
+	}
+
+	// Counters drop to zero, gauges persist, and timers and sets disappear.
+	l.flush(time.Now().Add(time.Second))
+	for target, want := range map[string]int{"sd.hits": 2, "sd.temp": 2, "sd.rt.mean": 1, "sd.visitors": 1} {
+		m, _ := d.Metric(target)
+		if m.Len() != want {
+			t.Errorf("second flush: %s has %d data points, want %d", target, m.Len(), want)
+		}
+	}
+	if m, _ := d.Metric("sd.hits"); m != nil {
+		if c, _ := m.Last(); c.N != 0 {
+			t.Errorf("second flush: got sd.hits %f, want 0", c.N)
+		}
+	}
+}
+
+func TestDashboard_serveStatsD(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("cannot listen on UDP:", err)
+	}
+	d := newTestDashboard()
+	stop := d.serveStatsD(conn, "", 10, 5*time.Millisecond)
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var m *Metric
+	for i := 0; i < 100 && m == nil; i++ {
+		client.Write([]byte("jobs:1|c"))
+		time.Sleep(5 * time.Millisecond)
+		m, _ = d.Metric("jobs")
+	}
+	stop()
+	if m == nil {
+		t.Fatal("serveStatsD(): no metric created")
+	}
+	if _, _, err := conn.ReadFrom(make([]byte, 1)); err == nil {
+		t.Error("serveStatsD(): connection still open after stop()")
+	}
+}
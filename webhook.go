@@ -0,0 +1,191 @@
+package grada
+
+// Delivery of alerts to webhooks.
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// webhookQueueSize is the number of alerts that a Webhook buffers.
+// If more alerts are waiting for delivery, new alerts get dropped.
+const webhookQueueSize = 100
+
+// Webhook delivers alerts as HTTP POST requests with a JSON body:
+//
+//	{
+//	  "id": "queue full/queue/firing/1508930214000000000",
+//	  "rule": "queue full",
+//	  "target": "queue",
+//	  "value": 150,
+//	  "time": "2017-10-25T11:16:54Z",
+//	  "state": "firing"
+//	}
+//
+// The value is null if the metric has no data. The id is the same for all
+// attempts to deliver an alert, so that receivers can detect duplicates.
+//
+// Use Webhook.Notify as the OnChange function of an AlertRule, or call
+// Dashboard.Watch(). A Webhook delivers alerts in the order they arrive,
+// in a goroutine of its own, which stops when the dashboard shuts down.
+// Change the fields before the first alert arrives.
+//
+// Create Webhooks with Dashboard.NewWebhook(), which starts the delivery.
+// Notify panics on a Webhook created in any other way,
+// such as &Webhook{URLs: urls}.
+type Webhook struct {
+	// URLs receive the alerts.
+	URLs []string
+
+	// Retries is the number of further attempts if a delivery fails
+	// due to a network error or a status code of 429 or 5xx. The default is 3.
+	Retries int
+
+	// Backoff is the waiting time before the first retry. It doubles
+	// with each further retry. The default is one second.
+	Backoff time.Duration
+
+	// Client sends the requests. The default has a timeout of 10 seconds.
+	Client *http.Client
+
+	queue chan Alert
+	mu    sync.Mutex
+	last  map[string]AlertState // the last state per rule and target
+}
+
+// webhookPayload is the JSON body of a webhook request.
+type webhookPayload struct {
+	ID     string    `json:"id"`
+	Rule   string    `json:"rule"`
+	Target string    `json:"target"`
+	Value  *float64  `json:"value"`
+	Time   time.Time `json:"time"`
+	State  string    `json:"state"`
+}
+
+// NewWebhook creates a Webhook that delivers alerts to the URLs.
+func (d *Dashboard) NewWebhook(urls ...string) *Webhook {
+	w := &Webhook{
+		URLs:    urls,
+		Retries: 3,
+		Backoff: time.Second,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+		queue:   make(chan Alert, webhookQueueSize),
+		last:    map[string]AlertState{},
+	}
+	queue := w.queue
+	shutdown := d.shutdownChan()
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for {
+			select {
+			case <-shutdown:
+				return
+			case a := <-queue:
+				w.deliver(a, shutdown)
+			}
+		}
+	}()
+	return w
+}
+
+// Notify queues a for delivery. Notify skips an alert if the previous
+// alert of the same rule and target had the same state.
+func (w *Webhook) Notify(a Alert) {
+	if w.queue == nil {
+		panic("grada: Webhook not created by Dashboard.NewWebhook()")
+	}
+	key := a.Rule + "/" + a.Target
+	w.mu.Lock()
+	last, ok := w.last[key]
+	if (ok && last == a.State) || (!ok && a.State == AlertResolved) {
+		w.mu.Unlock()
+		return
+	}
+	w.last[key] = a.State
+	w.mu.Unlock()
+
+	select {
+	case w.queue <- a:
+	default: // the queue is full
+	}
+}
+
+// deliver posts a to all URLs, with retries.
+func (w *Webhook) deliver(a Alert, shutdown chan struct{}) {
+	p := webhookPayload{
+		ID:     a.Rule + "/" + a.Target + "/" + a.State.String() + "/" + strconv.FormatInt(a.Time.UnixNano(), 10),
+		Rule:   a.Rule,
+		Target: a.Target,
+		Time:   a.Time,
+		State:  a.State.String(),
+	}
+	if !math.IsNaN(a.Value) && !math.IsInf(a.Value, 0) {
+		p.Value = &a.Value
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	for _, url := range w.URLs {
+		backoff := w.Backoff
+		for attempt := 0; ; attempt++ {
+			if !w.post(url, body) || attempt >= w.Retries {
+				break
+			}
+			select {
+			case <-shutdown:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+	}
+}
+
+// post sends body to url and reports whether a retry makes sense.
+func (w *Webhook) post(url string, body []byte) (retry bool) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return true
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// Watch watches the metric of target and posts to the webhook when
+// the condition has held for the duration, and again when it stops to hold.
+// The condition gets checked every interval. For example,
+//
+//	d.Watch("queue", Above(100), time.Minute, 10*time.Second, d.NewWebhook(url))
+//
+// notifies url when the queue has been longer than 100 for a minute, and
+//
+//	d.Watch("heartbeat", Absent(3*interval), 0, interval, d.NewWebhook(url))
+//
+// notifies url when the heartbeat metric has received no data for three intervals.
+// Watch uses the target as the name of the alert rule; see AddAlert() for
+// details, including annotations.
+//
+// Watching stops when the returned function is called,
+// or when the dashboard shuts down.
+func (d *Dashboard) Watch(target string, cond AlertCondition, duration, interval time.Duration, w *Webhook) (stop func(), err error) {
+	return d.AddAlert(AlertRule{
+		Name:      target,
+		Target:    target,
+		Condition: cond,
+		For:       duration,
+		OnChange:  w.Notify,
+	}, interval)
+}
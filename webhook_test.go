@@ -0,0 +1,190 @@
+package grada
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookReceiver records the payloads of webhook requests and fails
+// the first failures requests with the given status.
+type webhookReceiver struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	attempts int
+	payloads []webhookPayload
+	received chan struct{}
+}
+
+func (wr *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var p webhookPayload
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil || r.Method != http.MethodPost {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	wr.attempts++
+	if wr.attempts <= wr.failures {
+		w.WriteHeader(wr.status)
+		return
+	}
+	wr.payloads = append(wr.payloads, p)
+	wr.received <- struct{}{}
+}
+
+func (wr *webhookReceiver) wait(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-wr.received:
+		case <-time.After(time.Second):
+			t.Fatalf("received %d of %d webhook requests", i, n)
+		}
+	}
+}
+
+func TestWebhook_Notify(t *testing.T) {
+	now := time.Date(2017, time.October, 25, 11, 16, 54, 0, time.UTC)
+	tests := []struct {
+		name         string
+		failures     int
+		status       int
+		alerts       []Alert
+		wantAttempts int
+		want         []webhookPayload
+	}{
+		{
+			name: "delivered",
+			alerts: []Alert{
+				{"queue full", "queue", AlertFiring, 150, now},
+				{"queue full", "queue", AlertResolved, 20, now.Add(time.Second)},
+			},
+			wantAttempts: 2,
+			want: []webhookPayload{
+				{"queue full/queue/firing/1508930214000000000", "queue full", "queue", ptr(150), now, "firing"},
+				{"queue full/queue/resolved/1508930215000000000", "queue full", "queue", ptr(20), now.Add(time.Second), "resolved"},
+			},
+		},
+		{
+			name: "deduplicated",
+			alerts: []Alert{
+				{"queue full", "queue", AlertResolved, 20, now},
+				{"queue full", "queue", AlertFiring, 150, now},
+				{"queue full", "queue", AlertFiring, 160, now.Add(time.Second)},
+			},
+			wantAttempts: 1,
+			want: []webhookPayload{
+				{"queue full/queue/firing/1508930214000000000", "queue full", "queue", ptr(150), now, "firing"},
+			},
+		},
+		{
+			name:         "noValue",
+			alerts:       []Alert{{"missing", "heartbeat", AlertFiring, math.NaN(), now}},
+			wantAttempts: 1,
+			want: []webhookPayload{
+				{"missing/heartbeat/firing/1508930214000000000", "missing", "heartbeat", nil, now, "firing"},
+			},
+		},
+		{
+			name:         "retried",
+			failures:     2,
+			status:       http.StatusServiceUnavailable,
+			alerts:       []Alert{{"queue full", "queue", AlertFiring, 150, now}},
+			wantAttempts: 3,
+			want: []webhookPayload{
+				{"queue full/queue/firing/1508930214000000000", "queue full", "queue", ptr(150), now, "firing"},
+			},
+		},
+		{
+			name:     "clientError",
+			failures: 1,
+			status:   http.StatusBadRequest,
+			alerts: []Alert{
+				{"a", "a", AlertFiring, 1, now},
+				{"b", "b", AlertFiring, 2, now},
+			},
+			wantAttempts: 2,
+			want: []webhookPayload{
+				{"b/b/firing/1508930214000000000", "b", "b", ptr(2), now, "firing"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wr := &webhookReceiver{failures: tt.failures, status: tt.status, received: make(chan struct{}, 10)}
+			ts := httptest.NewServer(wr)
+			defer ts.Close()
+			d := newTestDashboard()
+			defer close(d.shutdownChan())
+
+			w := d.NewWebhook(ts.URL)
+			w.Backoff = time.Millisecond
+			for _, a := range tt.alerts {
+				w.Notify(a)
+			}
+			wr.wait(t, len(tt.want))
+
+			wr.mu.Lock()
+			defer wr.mu.Unlock()
+			if wr.attempts != tt.wantAttempts {
+				t.Errorf("got %d attempts, want %d", wr.attempts, tt.wantAttempts)
+			}
+			if len(wr.payloads) != len(tt.want) {
+				t.Fatalf("got %+v, want %+v", wr.payloads, tt.want)
+			}
+			for i, p := range wr.payloads {
+				want := tt.want[i]
+				if p.ID != want.ID || p.Rule != want.Rule || p.Target != want.Target || !p.Time.Equal(want.Time) || p.State != want.State ||
+					(p.Value == nil) != (want.Value == nil) || (p.Value != nil && *p.Value != *want.Value) {
+					t.Errorf("payload %d: got %+v, want %+v", i, p, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDashboard_Watch(t *testing.T) {
+	wr := &webhookReceiver{received: make(chan struct{}, 10)}
+	ts := httptest.NewServer(wr)
+	defer ts.Close()
+	d := newTestDashboard()
+	defer close(d.shutdownChan())
+
+	m, _ := d.CreateMetricWithBufSize("queue", 10)
+	m.Add(150)
+	stop, err := d.Watch("queue", Above(100), 0, 5*time.Millisecond, d.NewWebhook(ts.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	wr.wait(t, 1)
+	m.Add(20)
+	wr.wait(t, 1)
+
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+	if len(wr.payloads) != 2 || wr.payloads[0].State != "firing" || *wr.payloads[0].Value != 150 ||
+		wr.payloads[1].State != "resolved" || *wr.payloads[1].Value != 20 {
+		t.Errorf("Watch(): got %+v", wr.payloads)
+	}
+}
+
+func ptr(v float64) *float64 {
+	return &v
+}
+
+func TestWebhook_NotifyZeroValue(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Notify() on a Webhook not created by NewWebhook(): want panic")
+		}
+	}()
+	w := &Webhook{URLs: []string{"http://localhost:0"}}
+	w.Notify(Alert{"queue full", "queue", AlertFiring, 150, time.Now()})
+}
@@ -0,0 +1,329 @@
+package grada
+
+// An HTTP endpoint for pushing data points into grada from other processes.
+// The endpoint accepts JSON and the InfluxDB line protocol:
+// https://docs.influxdata.com/influxdb/v1/write_protocols/line_protocol_reference/
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxWriteSize is the maximum size of a request body for WriteHandler.
+const maxWriteSize = 32 << 20
+
+// point is a single data point of a JSON write request.
+type point struct {
+	Target    string          `json:"target"`
+	Value     *float64        `json:"value"`
+	Timestamp json.RawMessage `json:"timestamp"`
+}
+
+// WriteHandler returns an HTTP handler that lets other processes, shell scripts,
+// or cron jobs push data points into the dashboard's metrics.
+//
+// As the Grafana API server serves http.DefaultServeMux, the handler can be
+// added to this server by
+//
+//	http.Handle("/write", d.WriteHandler(token, time.Hour, time.Second))
+//
+// The handler accepts POST requests with the header
+// `Authorization: Bearer <token>`. WriteHandler panics if token is empty,
+// so that an unset configuration value cannot open the endpoint to everyone;
+// use InsecureWriteHandler() for an endpoint without authorization.
+//
+// With Content-Type "application/json", the body is a JSON array of data points:
+//
+//	[{"target": "queue.length", "value": 42, "timestamp": 1508930214000}, ...]
+//
+// The timestamp is either a number of milliseconds since the Unix epoch,
+// as in the responses to Grafana, or a string in RFC 3339 format.
+// Data points without a timestamp get the time of the request.
+//
+// With any other Content-Type, the body is in the InfluxDB line protocol:
+//
+//	cpu,host=server01 usage=0.64,idle=0.36 1508930214000000000
+//
+// Each field becomes a metric named measurement.field, except that fields named
+// "value" become a metric named measurement. Tags become labels in the form
+// that PrometheusHandler() understands; the example above creates the targets
+// `cpu.usage{host="server01"}` and `cpu.idle{host="server01"}`.
+// The query parameter "precision" sets the unit of the timestamps: "ns"
+// (the default), "us", "ms", or "s". Boolean fields become 1 or 0; string
+// fields are skipped.
+//
+// Missing metrics are created with a buffer size determined by timeRange
+// and interval, see CreateMetric(). The data points of a request are added
+// through Dashboard.AddBatch(), so that Grafana sees either none or all of them.
+// The handler responds with "204 No Content" on success, and with
+// "400 Bad Request" and a JSON error message if the body cannot be parsed.
+func (d *Dashboard) WriteHandler(token string, timeRange, interval time.Duration) http.Handler {
+	if token == "" {
+		panic("grada: WriteHandler needs a token; use InsecureWriteHandler for an endpoint without authorization")
+	}
+	return d.writeHandler(token, timeRange, interval)
+}
+
+// InsecureWriteHandler works like WriteHandler but does not check
+// authorization at all. Use it only in trusted networks.
+func (d *Dashboard) InsecureWriteHandler(timeRange, interval time.Duration) http.Handler {
+	return d.writeHandler("", timeRange, interval)
+}
+
+// writeHandler returns the handler for WriteHandler and InsecureWriteHandler.
+// If token is empty, the handler does not check authorization.
+func (d *Dashboard) writeHandler(token string, timeRange, interval time.Duration) http.Handler {
+	size := bufSizeFor(timeRange, interval)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if token != "" && !validToken(r.Header.Get("Authorization"), token) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		body := http.MaxBytesReader(w, r.Body, maxWriteSize)
+		now := time.Now()
+		var batch map[string][]Count
+		var err error
+		if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+			batch, err = parseJSONPoints(body, now)
+		} else {
+			batch, err = parseLineProtocol(body, r.URL.Query().Get("precision"), now)
+		}
+		if err != nil {
+			writeError(w, err, "cannot parse request body")
+			return
+		}
+
+		for target := range batch {
+			d.srv.metrics.GetOrCreate(target, size)
+		}
+		if err := d.AddBatch(batch); err != nil {
+			writeError(w, err, "cannot add data points")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// validToken reports whether the Authorization header auth
+// carries the bearer token.
+func validToken(auth, token string) bool {
+	got, ok := strings.CutPrefix(auth, "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// parseJSONPoints parses a JSON array of points. Points without
+// a timestamp get the time now.
+func parseJSONPoints(r io.Reader, now time.Time) (map[string][]Count, error) {
+	var points []point
+	if err := json.NewDecoder(r).Decode(&points); err != nil {
+		return nil, err
+	}
+	batch := map[string][]Count{}
+	for i, p := range points {
+		if p.Target == "" || p.Value == nil {
+			return nil, errors.New("point " + strconv.Itoa(i) + ": missing target or value")
+		}
+		t, err := parseJSONTimestamp(p.Timestamp, now)
+		if err != nil {
+			return nil, errors.New("point " + strconv.Itoa(i) + ": " + err.Error())
+		}
+		batch[p.Target] = append(batch[p.Target], Count{N: *p.Value, T: t})
+	}
+	return batch, nil
+}
+
+// parseJSONTimestamp parses a JSON number or string with parseTime().
+// If raw is empty or null, it returns now.
+func parseJSONTimestamp(raw json.RawMessage, now time.Time) (time.Time, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return now, nil
+	}
+	s := string(raw)
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return parseTime(s)
+}
+
+// parseTime parses a number of milliseconds since the Unix epoch
+// or a time in RFC 3339 format. Numbers must be finite and within
+// the range of time.UnixNano(), that is, between the years 1678 and 2262.
+func parseTime(s string) (time.Time, error) {
+	if ms, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.IsNaN(ms) || math.Abs(ms) >= math.MaxInt64/1e6 {
+			return time.Time{}, errors.New("invalid time " + s)
+		}
+		return time.Unix(0, int64(ms*1e6)), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, errors.New("invalid time " + s)
+	}
+	return t, nil
+}
+
+// precisions maps the values of the "precision" query parameter
+// to the unit of line protocol timestamps.
+var precisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"ns": time.Nanosecond,
+	"n":  time.Nanosecond,
+	"us": time.Microsecond,
+	"u":  time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// parseLineProtocol parses lines of the form
+//
+//	measurement[,tag=value...] field=value[,field=value...] [timestamp]
+//
+// Lines without a timestamp get the time now.
+func parseLineProtocol(r io.Reader, precision string, now time.Time) (map[string][]Count, error) {
+	unit, ok := precisions[precision]
+	if !ok {
+		return nil, errors.New("invalid precision " + precision)
+	}
+	batch := map[string][]Count{}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxWriteSize)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if err := parseLine(line, unit, now, batch); err != nil {
+			return nil, errors.New("line " + strconv.Itoa(n) + ": " + err.Error())
+		}
+	}
+	return batch, sc.Err()
+}
+
+// maxExcerpt is the maximum length of the excerpts of lines in error messages.
+const maxExcerpt = 40
+
+// excerpt returns the start of line for error messages,
+// which the handler sends back to the client.
+func excerpt(line string) string {
+	if len(line) <= maxExcerpt {
+		return line
+	}
+	return strings.ToValidUTF8(line[:maxExcerpt], "") + "..."
+}
+
+// parseLine parses a single line of the line protocol
+// and adds its fields to batch.
+func parseLine(line string, unit time.Duration, now time.Time, batch map[string][]Count) error {
+	parts := splitEscaped(line, ' ')
+	if len(parts) < 2 || len(parts) > 3 {
+		return errors.New("invalid line: " + excerpt(line))
+	}
+	t := now
+	if len(parts) == 3 {
+		ts, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || ts > math.MaxInt64/int64(unit) || ts < math.MinInt64/int64(unit) {
+			return errors.New("invalid timestamp: " + excerpt(line))
+		}
+		t = time.Unix(0, ts*int64(unit))
+	}
+
+	series := splitEscaped(parts[0], ',')
+	measurement := unescape(series[0])
+	if measurement == "" {
+		return errors.New("missing measurement: " + excerpt(line))
+	}
+	var labels []label
+	for _, tag := range series[1:] {
+		k, v, ok := cutEscaped(tag, '=')
+		if !ok {
+			return errors.New("invalid tag: " + excerpt(line))
+		}
+		labels = append(labels, label{unescape(k), unescape(v)})
+	}
+	sortLabels(labels)
+	suffix := formatLabels(labels)
+
+	for _, field := range splitEscaped(parts[1], ',') {
+		k, v, ok := cutEscaped(field, '=')
+		if !ok || k == "" || v == "" {
+			return errors.New("invalid field: " + excerpt(line))
+		}
+		var f float64
+		switch {
+		case v[0] == '"':
+			continue // string field
+		case v == "t" || v == "T" || v == "true" || v == "True" || v == "TRUE":
+			f = 1
+		case v == "f" || v == "F" || v == "false" || v == "False" || v == "FALSE":
+			f = 0
+		default:
+			var err error
+			f, err = strconv.ParseFloat(strings.TrimRight(v, "iu"), 64)
+			if err != nil {
+				return errors.New("invalid field value: " + excerpt(line))
+			}
+		}
+		target := measurement
+		if k = unescape(k); k != "value" {
+			target += "." + k
+		}
+		target += suffix
+		batch[target] = append(batch[target], Count{N: f, T: t})
+	}
+	return nil
+}
+
+// splitEscaped splits s at each sep that is neither escaped by a backslash
+// nor inside a double-quoted string.
+func splitEscaped(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cutEscaped works like strings.Cut but skips a sep that is escaped by a backslash.
+func cutEscaped(s string, sep byte) (before, after string, found bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// unescape removes the backslashes that escape commas, spaces, and equal signs.
+func unescape(s string) string {
+	return strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=").Replace(s)
+}
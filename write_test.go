@@ -0,0 +1,208 @@
+package grada
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_parseLineProtocol(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tests := []struct {
+		name      string
+		body      string
+		precision string
+		want      map[string][]Count
+		wantErr   bool
+	}{
+		{
+			"fields",
+			"cpu,host=server01,dc=eu usage=0.64,idle=0.36 1508930214000000000\n",
+			"",
+			map[string][]Count{
+				`cpu.usage{dc="eu",host="server01"}`: {{N: 0.64, T: time.Unix(1508930214, 0)}},
+				`cpu.idle{dc="eu",host="server01"}`:  {{N: 0.36, T: time.Unix(1508930214, 0)}},
+			},
+			false,
+		},
+		{
+			"valueFieldAndPrecision",
+			"queue value=3i 1508930214\nqueue value=4i 1508930215\n",
+			"s",
+			map[string][]Count{
+				"queue": {{N: 3, T: time.Unix(1508930214, 0)}, {N: 4, T: time.Unix(1508930215, 0)}},
+			},
+			false,
+		},
+		{
+			"escapesTypesAndNoTimestamp",
+			`# comment
+disk\ io,path=C:\,D: busy=t,msg="a, b=c",err=F`,
+			"",
+			map[string][]Count{
+				`disk io.busy{path="C:,D:"}`: {{N: 1, T: now}},
+				`disk io.err{path="C:,D:"}`:  {{N: 0, T: now}},
+			},
+			false,
+		},
+		{"missingFields", "cpu 1508930214", "", nil, true},
+		{"invalidValue", "cpu usage=high", "", nil, true},
+		{"invalidTimestamp", "cpu usage=1 yesterday", "", nil, true},
+		{"timestampOverflow", "cpu usage=1 99999999999999", "s", nil, true},
+		{"timestampUnderflow", "cpu usage=1 -99999999999999", "s", nil, true},
+		{"invalidTag", "cpu,host usage=1", "", nil, true},
+		{"invalidPrecision", "cpu usage=1", "h", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLineProtocol(strings.NewReader(tt.body), tt.precision, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseLineProtocol(): error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !cmp.Equal(got, tt.want) {
+				t.Errorf("parseLineProtocol(): diff:\n%s", cmp.Diff(got, tt.want))
+			}
+		})
+	}
+}
+
+func Test_parseTime(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    time.Time
+		wantErr bool
+	}{
+		{"1508930214000", time.Unix(1508930214, 0), false},
+		{"1.5", time.Unix(0, 1500000), false},
+		{"-1000", time.Unix(-1, 0), false},
+		{"2017-10-25T11:16:54Z", time.Date(2017, 10, 25, 11, 16, 54, 0, time.UTC), false},
+		{"NaN", time.Time{}, true},
+		{"Inf", time.Time{}, true},
+		{"-Inf", time.Time{}, true},
+		{"1e30", time.Time{}, true},
+		{"-1e30", time.Time{}, true},
+		{"9223372036855", time.Time{}, true}, // just above MaxInt64 nanoseconds
+		{"yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseTime(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTime(): error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(tt.want) {
+				t.Errorf("parseTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseJSONPoints(t *testing.T) {
+	now := time.Unix(1000, 0)
+	body := `[
+		{"target": "a", "value": 1, "timestamp": 1508930214000},
+		{"target": "a", "value": 2.5, "timestamp": "2017-10-25T11:16:55.5Z"},
+		{"target": "b", "value": 0}
+	]`
+	got, err := parseJSONPoints(strings.NewReader(body), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]Count{
+		"a": {{N: 1, T: time.Unix(1508930214, 0)}, {N: 2.5, T: time.Date(2017, 10, 25, 11, 16, 55, 5e8, time.UTC)}},
+		"b": {{N: 0, T: now}},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("parseJSONPoints(): diff:\n%s", cmp.Diff(got, want))
+	}
+
+	for _, bad := range []string{
+		`{"target": "a", "value": 1}`,
+		`[{"target": "a"}]`,
+		`[{"value": 1}]`,
+		`[{"target": "a", "value": 1, "timestamp": "yesterday"}]`,
+		`[{"target": "a", "value": 1, "timestamp": true}]`,
+	} {
+		if _, err := parseJSONPoints(strings.NewReader(bad), now); err == nil {
+			t.Errorf("parseJSONPoints(%s): want error", bad)
+		}
+	}
+}
+
+func TestDashboard_WriteHandler(t *testing.T) {
+	d := newTestDashboard()
+	h := d.WriteHandler("secret", time.Minute, time.Second)
+
+	tests := []struct {
+		name        string
+		method      string
+		auth        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{"json", "POST", "Bearer secret", "application/json; charset=utf-8", `[{"target": "jobs.done", "value": 7}]`, http.StatusNoContent},
+		{"lineProtocol", "POST", "Bearer secret", "text/plain", "jobs,queue=mail done=3", http.StatusNoContent},
+		{"wrongToken", "POST", "Bearer guess", "text/plain", "jobs done=3", http.StatusUnauthorized},
+		{"noToken", "POST", "", "text/plain", "jobs done=3", http.StatusUnauthorized},
+		{"get", "GET", "Bearer secret", "", "", http.StatusMethodNotAllowed},
+		{"badBody", "POST", "Bearer secret", "application/json", `[{"target": "x"`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/write", strings.NewReader(tt.body))
+			r.Header.Set("Authorization", tt.auth)
+			r.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("got status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+
+	for target, want := range map[string]float64{"jobs.done": 7, `jobs.done{queue="mail"}`: 3} {
+		m, err := d.Metric(target)
+		if err != nil {
+			t.Errorf("%s: %v", target, err)
+			continue
+		}
+		if c, _ := m.Last(); c.N != want || m.Len() != 1 {
+			t.Errorf("%s: got %v (len %d), want %f", target, c, m.Len(), want)
+		}
+	}
+	if _, err := d.Metric("x"); err == nil {
+		t.Error("a rejected request created a metric")
+	}
+}
+
+func TestDashboard_InsecureWriteHandler(t *testing.T) {
+	d := newTestDashboard()
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("WriteHandler() with an empty token: want panic")
+			}
+		}()
+		d.WriteHandler("", time.Minute, time.Second)
+	}()
+
+	h := d.InsecureWriteHandler(time.Minute, time.Second)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/write", strings.NewReader("jobs done=3")))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("got status %d, want %d: %s", w.Code, http.StatusNoContent, w.Body)
+	}
+
+	// Error messages contain only the start of a long line.
+	w = httptest.NewRecorder()
+	line := "jobs done=" + strings.Repeat("x", 1000)
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/write", strings.NewReader(line)))
+	if w.Code != http.StatusBadRequest || strings.Contains(w.Body.String(), line) || !strings.Contains(w.Body.String(), line[:maxExcerpt]+"...") {
+		t.Errorf("got status %d: %s", w.Code, w.Body)
+	}
+}